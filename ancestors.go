@@ -0,0 +1,97 @@
+package sfr
+
+import (
+	"fmt"
+	"sort"
+)
+
+//AncestorSummary describes a Record above SplitOnRecordName (such as a batch
+//header) once it is complete.
+type AncestorSummary struct {
+	Record *Record
+	//SplitRecords is the number of split records sent beneath Record.
+	SplitRecords int
+	//Records is the number of Records read beneath Record.
+	Records int
+	//LastLineNum is the line number of the last Record read beneath Record (or
+	//of Record itself if there were none).
+	LastLineNum int
+}
+
+//AncestorCompleteFunc is called with an AncestorSummary when a Record above
+//SplitOnRecordName is complete.
+type AncestorCompleteFunc func(summary AncestorSummary) error
+
+//pendingItem is a completed split record or ancestor waiting to be returned
+//(or passed to AncestorComplete) by next.
+type pendingItem struct {
+	record   *Record
+	ancestor *AncestorSummary
+}
+
+//isAncestorLevel returns true if Records named name are above SplitOnRecordName.
+func (ps *parseState) isAncestorLevel(name string) bool {
+	return ps.hasAncestor(ps.p.SplitOnRecordName, name)
+}
+
+//openAncestor starts tracking rec as an ancestor, completing the open
+//ancestors (& the split records beneath them) which it replaces.
+func (ps *parseState) openAncestor(rec *Record) {
+	ps.closeSplits(rec.Name)
+	ps.closeAncestors(rec.Name)
+	summary := &AncestorSummary{Record: rec, LastLineNum: rec.LineNum}
+	ps.ancestorSummaries[rec.Name] = summary
+	ps.uncompletedAncestors[rec] = summary
+}
+
+//countDescendant counts rec beneath each of its open ancestors.
+func (ps *parseState) countDescendant(rec *Record) {
+	for _, name := range ps.ancestors[rec.Name] {
+		if summary, ok := ps.ancestorSummaries[name]; ok {
+			summary.Records++
+			summary.LastLineNum = ps.lineNum
+		}
+	}
+}
+
+//countSplitRecord counts a split record being returned beneath each of its
+//ancestors which have not yet been passed to AncestorComplete.
+func (ps *parseState) countSplitRecord(rec *Record) {
+	for parent := rec.Parent; parent != nil; parent = parent.Parent {
+		if summary, ok := ps.uncompletedAncestors[parent]; ok {
+			summary.SplitRecords++
+		}
+	}
+}
+
+//closeAncestors completes the open ancestors named name & those beneath them
+//in the hierarchy (or every open ancestor if name is empty), adding them to
+//pending deepest first.
+func (ps *parseState) closeAncestors(name string) {
+	closing := make([]*AncestorSummary, 0)
+	for n, summary := range ps.ancestorSummaries {
+		if name == "" || n == name || ps.hasAncestor(n, name) {
+			closing = append(closing, summary)
+			delete(ps.ancestorSummaries, n)
+		}
+	}
+	sort.Slice(closing, func(i, j int) bool {
+		return len(ps.ancestors[closing[i].Record.Name]) > len(ps.ancestors[closing[j].Record.Name])
+	})
+	for _, summary := range closing {
+		ps.pending = append(ps.pending, pendingItem{ancestor: summary})
+	}
+}
+
+//completeAncestor passes a completed ancestor to AncestorComplete. Like a
+//RecordProcessor's, its errors stop the parse (as a ProcessorError wrapping
+//the error) rather than being passed to the ErrorHandler.
+func (ps *parseState) completeAncestor(summary *AncestorSummary) error {
+	name := summary.Record.Name
+	delete(ps.uncompletedAncestors, summary.Record)
+	err := ps.p.AncestorComplete(*summary)
+	if err == nil {
+		return nil
+	}
+	return ProcessorError{RecordName: name, LineNum: summary.Record.LineNum, Err: fmt.Errorf("AncestorComplete failed: %w", err)}
+}
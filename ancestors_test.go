@@ -0,0 +1,111 @@
+package sfr
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAncestorComplete(t *testing.T) {
+	config, err := os.Open("testfiles/DelimitedPurchaseOrder/po.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make([]string, 0)
+	p, err := NewParser(config, func(record *Record) error {
+		if record == nil {
+			t.Error("RecordProcessor called with nil")
+			return nil
+		}
+		events = append(events, fmt.Sprintf("%s %s", record.Name, record.Fields[1].Value))
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summaries := make([]AncestorSummary, 0)
+	p.AncestorComplete = func(summary AncestorSummary) error {
+		summaries = append(summaries, summary)
+		events = append(events, fmt.Sprintf("%s %s complete", summary.Record.Name, summary.Record.Fields[1].Value))
+		return nil
+	}
+	if err = p.ParseFile("testfiles", "DelimitedPurchaseOrder", "po.dat"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"POHeader 1000000001",
+		"POHeader 1000000002",
+		"POBatch 1111-1111-1111 complete",
+		"POHeader 1000000003",
+		"POBatch 2222-2222-2222-2 complete",
+	}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Fatalf("Expected %v, got %v", expected, events)
+	}
+	if summaries[0].SplitRecords != 2 || summaries[0].Records != 12 || summaries[0].LastLineNum != 13 {
+		t.Errorf("Unexpected first batch summary %+v", summaries[0])
+	}
+	if summaries[1].SplitRecords != 1 || summaries[1].Records != 6 || summaries[1].LastLineNum != 20 {
+		t.Errorf("Unexpected second batch summary %+v", summaries[1])
+	}
+
+	//Errors stop the parse as ProcessorErrors, even if the ErrorHandler
+	//ignores errors.
+	failure := errors.New("audit failed")
+	p.AncestorComplete = func(summary AncestorSummary) error {
+		return failure
+	}
+	p.ErrorHandler = func(err error) error { return nil }
+	err = p.ParseFile("testfiles", "DelimitedPurchaseOrder", "po.dat")
+	if pe, ok := err.(ProcessorError); !ok || pe.RecordName != "POBatch" || pe.LineNum != 1 {
+		t.Errorf("Expected a ProcessorError for POBatch, got %v", err)
+	}
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected the ProcessorError to wrap %v, got %v", failure, err)
+	}
+}
+
+func TestAncestorCompleteWorkers(t *testing.T) {
+	config, err := os.Open("testfiles/DelimitedPurchaseOrder/po.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	events := make([]string, 0)
+	p, err := NewParser(config, func(record *Record) error {
+		//Slow processors must still finish before their batch completes.
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, record.Name)
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Workers = 4
+	p.AncestorComplete = func(summary AncestorSummary) error {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, summary.Record.Name)
+		return nil
+	}
+	if err = p.ParseFile("testfiles", "DelimitedPurchaseOrder", "po.dat"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "[POHeader POHeader POBatch POHeader POBatch]"
+	if fmt.Sprint(events) != expected {
+		t.Errorf("Expected %s, got %v", expected, events)
+	}
+
+	//Buffered joins are rejected when parsing, as AncestorComplete is set after NewParser.
+	p.JoinMode = BufferedJoinMode
+	err = p.ParseFile("testfiles", "DelimitedPurchaseOrder", "po.dat")
+	var ces ConfigurationErrors
+	if !errors.As(err, &ces) {
+		t.Errorf("Expected ConfigurationErrors for AncestorComplete with a Buffered join, got %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package sfr
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+//processorJob is a split Record queued for a worker.
+type processorJob struct {
+	seq    int
+	record *Record
+}
+
+//processorResult is the outcome of a processorJob. skipped is set if the
+//job came after a failed job so the processor wasn't called.
+type processorResult struct {
+	processorJob
+	err     error
+	skipped bool
+}
+
+//parseConcurrent reads split Records from ps & passes them to processor on
+//p.Workers goroutines. Once a processor fails no more Records are queued &
+//queued Records after the failed one are skipped. The error of the earliest
+//failing Record (or, with UnorderedDelivery, the first error to arrive) is
+//returned as a ProcessorError, cancelling the context passed to running
+//processors. Other errors are passed to the ErrorHandler as they are read.
+func (p *Parser) parseConcurrent(ctx context.Context, ps *parseState, processor ContextRecordProcessor) (err error) {
+	queueSize := p.WorkerQueueSize
+	if queueSize < 1 {
+		queueSize = p.Workers
+	}
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan processorJob, queueSize)
+	//results can hold every job in flight so workers never block sending.
+	maxInFlight := queueSize + p.Workers
+	results := make(chan processorResult, maxInFlight)
+	//failedSeq is the lowest seq of the jobs which have failed.
+	failedSeq := int64(math.MaxInt64)
+	var wg sync.WaitGroup
+	for i := 0; i < p.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				result := processorResult{processorJob: job}
+				if int64(job.seq) > atomic.LoadInt64(&failedSeq) {
+					result.skipped = true
+				} else if result.err = workerCtx.Err(); result.err == nil {
+					result.err = processor(workerCtx, job.record)
+				}
+				results <- result
+			}
+		}()
+	}
+	//stop shuts down the workers, discarding any outstanding results.
+	jobsClosed := false
+	stop := func() {
+		cancel()
+		if !jobsClosed {
+			close(jobs)
+			jobsClosed = true
+		}
+		wg.Wait()
+	}
+	defer stop()
+
+	//inFlight counts the jobs queued whose results haven't been handled (in
+	//input order unless UnorderedDelivery is set), so at most maxInFlight
+	//results are ever held in pending.
+	inFlight := 0
+	nextSeq := 0
+	pending := make(map[int]processorResult)
+	failed := false
+	//handle checks a result for an error. In input order, an error is only
+	//returned once the results of all earlier jobs have been handled.
+	handle := func(result processorResult) error {
+		if result.err != nil {
+			failed = true
+			if int64(result.seq) < atomic.LoadInt64(&failedSeq) {
+				atomic.StoreInt64(&failedSeq, int64(result.seq))
+			}
+		}
+		if p.UnorderedDelivery {
+			inFlight--
+			return p.handleProcessorResult(result)
+		}
+		pending[result.seq] = result
+		for {
+			next, ok := pending[nextSeq]
+			if !ok {
+				return nil
+			}
+			delete(pending, nextSeq)
+			nextSeq++
+			inFlight--
+			if err := p.handleProcessorResult(next); err != nil {
+				return err
+			}
+		}
+	}
+
+	//AncestorComplete is called once the split records beneath the ancestor
+	//have been processed.
+	ps.drain = func() error {
+		for inFlight > 0 {
+			if err := handle(<-results); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for seq := 0; ; seq++ {
+		record, err := ps.next()
+		if err != nil {
+			return err
+		}
+		if err = ps.checkContext(); err != nil {
+			return err
+		}
+		//Wait for a free slot, handling any results which have already arrived.
+		for inFlight == maxInFlight {
+			if err = handle(<-results); err != nil {
+				return err
+			}
+		}
+		for drained := false; !drained; {
+			select {
+			case result := <-results:
+				if err = handle(result); err != nil {
+					return err
+				}
+			default:
+				drained = true
+			}
+		}
+		if failed {
+			//Queue nothing more, waiting for the results of earlier jobs to
+			//find the error to return.
+			for {
+				if err = handle(<-results); err != nil {
+					return err
+				}
+			}
+		}
+		if record == nil && ps.done && ps.sent > 0 {
+			//The last split record was sent before its ancestors completed.
+			break
+		}
+		inFlight++
+		jobs <- processorJob{seq: seq, record: record}
+		if ps.done {
+			break
+		}
+	}
+	close(jobs)
+	jobsClosed = true
+	for inFlight > 0 {
+		if err = handle(<-results); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//handleProcessorResult returns any error from a RecordProcessor as a
+//ProcessorError.
+func (p *Parser) handleProcessorResult(result processorResult) error {
+	if result.err == nil {
+		return nil
+	}
+	procErr := ProcessorError{RecordName: p.SplitOnRecordName, Err: result.err}
+	if result.record != nil {
+		procErr.RecordName = result.record.Name
+		procErr.LineNum = result.record.LineNum
+	}
+	return procErr
+}
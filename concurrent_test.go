@@ -0,0 +1,136 @@
+package sfr
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//manyInvoices returns HierarchyData style data containing n invoices.
+func manyInvoices(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "010~INV%05d~12345~17-JUL-2019\n030~0001~Line One\n033ACCTNUM001\n", i)
+	}
+	return sb.String()
+}
+
+func TestParseConcurrent(t *testing.T) {
+	for _, unordered := range []bool{false, true} {
+		var mu sync.Mutex
+		seen := make(map[interface{}]bool)
+		p, err := NewParser(
+			ioutil.NopCloser(strings.NewReader(JoinCfg)),
+			func(record *Record) error {
+				invnum, err := record.GetField("InvoiceNumber")
+				if err != nil {
+					return err
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				seen[invnum.Value] = true
+				return nil
+			},
+			nil,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Workers = 4
+		p.UnorderedDelivery = unordered
+		if err = p.Parse(ioutil.NopCloser(strings.NewReader(manyInvoices(100)))); err != nil {
+			t.Fatal(err)
+		}
+		if len(seen) != 100 {
+			t.Errorf("Expected 100 invoices, got %d", len(seen))
+		}
+	}
+}
+
+func TestParseConcurrentProcessorError(t *testing.T) {
+	failure := errors.New("insert failed")
+	for _, workers := range []int{1, 4} {
+		p, err := NewParser(
+			ioutil.NopCloser(strings.NewReader(JoinCfg)),
+			func(record *Record) error {
+				invnum, _ := record.GetField("InvoiceNumber")
+				if invnum.Value == "INV00010" || invnum.Value == "INV00050" {
+					return failure
+				}
+				return nil
+			},
+			//Processor errors are returned even if the ErrorHandler ignores errors.
+			func(err error) error { return nil },
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Workers = workers
+		err = p.Parse(ioutil.NopCloser(strings.NewReader(manyInvoices(100))))
+		if workers == 1 {
+			//A serial parse returns the processor's error as it is.
+			if err != failure {
+				t.Errorf("Expected %v, got %v", failure, err)
+			}
+			continue
+		}
+		var procErr ProcessorError
+		if !errors.As(err, &procErr) {
+			t.Fatalf("Expected a ProcessorError, got %v", err)
+		}
+		if !errors.Is(err, failure) {
+			t.Errorf("Expected ProcessorError to wrap %v, got %v", failure, procErr.Err)
+		}
+		if procErr.LineNum != 31 || procErr.RecordName != "InvoiceHeader" {
+			t.Errorf("Expected InvoiceHeader on line 31, got %s on line %d", procErr.RecordName, procErr.LineNum)
+		}
+	}
+}
+
+func TestParseConcurrentStopsOnLaterError(t *testing.T) {
+	//A later Record failing while an earlier one is still being processed
+	//stops the scan, but the earlier Record's error is returned first.
+	for _, earlierFails := range []bool{false, true} {
+		failure := errors.New("insert failed")
+		var processed int64
+		p, err := NewParser(
+			ioutil.NopCloser(strings.NewReader(JoinCfg)),
+			func(record *Record) error {
+				atomic.AddInt64(&processed, 1)
+				invnum, _ := record.GetField("InvoiceNumber")
+				switch invnum.Value {
+				case "INV00000":
+					time.Sleep(100 * time.Millisecond)
+					if earlierFails {
+						return failure
+					}
+				case "INV00001":
+					return failure
+				}
+				return nil
+			},
+			nil,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Workers = 4
+		err = p.Parse(ioutil.NopCloser(strings.NewReader(manyInvoices(5000))))
+		var procErr ProcessorError
+		expectedLine := 4
+		if earlierFails {
+			expectedLine = 1
+		}
+		if !errors.As(err, &procErr) || procErr.LineNum != expectedLine {
+			t.Errorf("Expected a ProcessorError on line %d, got %v", expectedLine, err)
+		}
+		if n := atomic.LoadInt64(&processed); n > 20 {
+			t.Errorf("Expected the scan to stop, %d Records were processed", n)
+		}
+	}
+}
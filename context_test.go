@@ -0,0 +1,173 @@
+package sfr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseContextCancelledByProcessor(t *testing.T) {
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(JoinCfg)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	p.ContextRecordProcessor = func(ctx context.Context, record *Record) error {
+		calls++
+		cancel()
+		return nil
+	}
+	err = p.ParseContext(ctx, ioutil.NopCloser(strings.NewReader(HierarchyData)))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	var ctxErr ContextError
+	if !errors.As(err, &ctxErr) || ctxErr.LineNum != 7 {
+		t.Errorf("Expected ContextError on line 7, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call to the processor, got %d", calls)
+	}
+}
+
+//blockingReader returns first, then blocks until release is closed before
+//returning rest. blocked is closed when it starts blocking.
+type blockingReader struct {
+	first, rest string
+	blocked     chan struct{}
+	release     <-chan struct{}
+}
+
+func newBlockingReader(data string, lines int, release <-chan struct{}) *blockingReader {
+	parts := strings.SplitAfterN(data, "\n", lines+1)
+	return &blockingReader{
+		first:   strings.Join(parts[:lines], ""),
+		rest:    parts[lines],
+		blocked: make(chan struct{}),
+		release: release,
+	}
+}
+
+func (br *blockingReader) Read(b []byte) (int, error) {
+	if br.first != "" {
+		n := copy(b, br.first)
+		br.first = br.first[n:]
+		return n, nil
+	}
+	if br.blocked != nil {
+		close(br.blocked)
+		br.blocked = nil
+		<-br.release
+	}
+	if br.rest == "" {
+		return 0, io.EOF
+	}
+	n := copy(b, br.rest)
+	br.rest = br.rest[n:]
+	return n, nil
+}
+
+func (br *blockingReader) Close() error {
+	return nil
+}
+
+//checkContextError checks that err is a ContextError wrapping target which
+//stopped parsing on lineNum.
+func checkContextError(t *testing.T, err, target error, lineNum int) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("Expected %v, got %v", target, err)
+	}
+	var ctxErr ContextError
+	if !errors.As(err, &ctxErr) || ctxErr.LineNum != lineNum {
+		t.Errorf("Expected ContextError on line %d, got %v", lineNum, err)
+	}
+}
+
+func TestParseContextCancelledBetweenLines(t *testing.T) {
+	calls := 0
+	p, err := NewParser(
+		ioutil.NopCloser(strings.NewReader(JoinCfg)),
+		RecordProcessor(func(record *Record) error {
+			calls++
+			return nil
+		}),
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	release := make(chan struct{})
+	source := newBlockingReader(HierarchyData, 3, release)
+	blocked := source.blocked
+	go func() {
+		//Cancel while the Parser waits for line 4.
+		<-blocked
+		cancel()
+		close(release)
+	}()
+	err = p.ParseContext(ctx, source)
+	//The line which was waiting when ctx was cancelled is read before the
+	//context is checked.
+	checkContextError(t, err, context.Canceled, 4)
+	if calls != 0 {
+		t.Errorf("Expected no calls to the processor, got %d", calls)
+	}
+	if !strings.Contains(err.Error(), "line 4") {
+		t.Errorf("Expected the error message to contain the line number, got %q", err)
+	}
+}
+
+func TestParseContextDeadline(t *testing.T) {
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(JoinCfg)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	//The reader blocks until the deadline has passed.
+	err = p.ParseContext(ctx, newBlockingReader(HierarchyData, 3, ctx.Done()))
+	checkContextError(t, err, context.DeadlineExceeded, 4)
+}
+
+func TestParseFileContext(t *testing.T) {
+	config, err := os.Open("testfiles/DelimitedPurchaseOrder/po.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	p, err := NewParser(
+		config,
+		RecordProcessor(func(record *Record) error {
+			calls++
+			return nil
+		}),
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.ParseFileContext(context.Background(), "testfiles", "DelimitedPurchaseOrder", "po.dat"); err != nil {
+		t.Fatal(err)
+	}
+	if calls == 0 {
+		t.Fatal("Expected the processor to be called")
+	}
+	calls = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = p.ParseFileContext(ctx, "testfiles", "DelimitedPurchaseOrder", "po.dat")
+	checkContextError(t, err, context.Canceled, 0)
+	if calls != 0 {
+		t.Errorf("Expected no calls to the processor, got %d", calls)
+	}
+}
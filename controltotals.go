@@ -0,0 +1,125 @@
+package sfr
+
+import (
+	"fmt"
+	"math"
+)
+
+//ControlTotal declares that the Field named FieldName of a Record (usually a
+//trailer) must equal the sum of the Fields named SumFieldName of the Records
+//named RecordName read since the last Record named ResetOnRecordName (or the
+//start of the source). If SumFieldName is empty, the Field must equal the
+//number of Records named RecordName instead. For example, the TotalQty of a
+//POTrailer must equal the sum of POLine.Qty since the last POBatch:
+//
+//	{"FieldName": "TotalQty", "RecordName": "POLine", "SumFieldName": "Qty", "ResetOnRecordName": "POBatch"}
+//
+//Mismatches are passed to the ErrorHandler as ControlTotalErrors.
+type ControlTotal struct {
+	FieldName         string
+	RecordName        string
+	SumFieldName      string
+	ResetOnRecordName string
+}
+
+//accumulator holds the running total of a ControlTotal.
+type accumulator struct {
+	recordName string
+	rule       ControlTotal
+	total      float64
+}
+
+//newAccumulators returns an accumulator for each ControlTotal.
+func newAccumulators(recDefs []*RecordDefinition) []*accumulator {
+	accs := make([]*accumulator, 0)
+	for _, recDef := range recDefs {
+		for _, rule := range recDef.ControlTotals {
+			accs = append(accs, &accumulator{recordName: recDef.Name, rule: rule})
+		}
+	}
+	return accs
+}
+
+//accumulate checks the ControlTotals of rec, then resets & adds to the
+//accumulators as required by rec.
+func (ps *parseState) accumulate(rec *Record) error {
+	for _, acc := range ps.accumulators {
+		if rec.Name == acc.recordName {
+			if err := ps.checkControlTotal(acc, rec); err != nil {
+				return err
+			}
+		}
+		if rec.Name == acc.rule.ResetOnRecordName {
+			acc.total = 0
+		}
+		if rec.Name != acc.rule.RecordName {
+			continue
+		}
+		if acc.rule.SumFieldName == "" {
+			acc.total++
+			continue
+		}
+		//Values which could not be parsed have already been reported.
+		if fld, ok := rec.field(acc.rule.SumFieldName); ok {
+			if val, ok := fld.Value.(float64); ok {
+				acc.total += val
+			}
+		}
+	}
+	return nil
+}
+
+//checkControlTotal compares the control Field of rec with the accumulated total.
+func (ps *parseState) checkControlTotal(acc *accumulator, rec *Record) error {
+	fld, ok := rec.field(acc.rule.FieldName)
+	if !ok {
+		return nil
+	}
+	expected, ok := fld.Value.(float64)
+	if !ok {
+		if fld.Value == nil {
+			//The value could not be parsed & has already been reported.
+			return nil
+		}
+		return ps.p.ErrorHandler(FieldParseError{
+			Text:       fmt.Sprintf("Control total must be a Number, got %T", fld.Value),
+			RecordName: rec.Name,
+			FieldName:  fld.Name,
+			LineNum:    rec.LineNum,
+			Column:     fld.Start,
+		})
+	}
+	//Allow for rounding when summing decimals.
+	if math.Abs(expected-acc.total) <= 1e-9*math.Max(1, math.Abs(expected)) {
+		return nil
+	}
+	return ps.p.ErrorHandler(ControlTotalError{
+		RecordName: rec.Name,
+		FieldName:  fld.Name,
+		LineNum:    rec.LineNum,
+		Rule:       acc.rule,
+		Expected:   expected,
+		Actual:     acc.total,
+	})
+}
+
+//validateControlTotals checks the ControlTotals of recDef refer to defined
+//Records & Fields.
+func validateControlTotals(recDef *RecordDefinition, recDefs map[string]*RecordDefinition) []error {
+	errs := make([]error, 0)
+	for _, rule := range recDef.ControlTotals {
+		if !recDef.hasField(rule.FieldName) {
+			errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": ControlTotal field \"%s\" is not defined", recDef.Name, rule.FieldName))
+		}
+		summed, ok := recDefs[rule.RecordName]
+		if !ok {
+			errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": ControlTotal RecordName \"%s\" does not match a RecordDefinition", recDef.Name, rule.RecordName))
+		} else if rule.SumFieldName != "" && !summed.hasField(rule.SumFieldName) {
+			errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": ControlTotal SumFieldName \"%s\" is not defined by \"%s\"", recDef.Name, rule.SumFieldName, rule.RecordName))
+		}
+		if _, ok := recDefs[rule.ResetOnRecordName]; rule.ResetOnRecordName != "" && !ok {
+			errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": ControlTotal ResetOnRecordName \"%s\" does not match a RecordDefinition", recDef.Name, rule.ResetOnRecordName))
+		}
+	}
+	return errs
+}
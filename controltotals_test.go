@@ -0,0 +1,98 @@
+package sfr
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const controlTotalCfg = `
+{
+	"SplitOnRecordName": "POLine",
+	"RecordDefinitions": [
+		{
+			"Name": "POBatch",
+			"MatchExpression": "^B",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"}
+			]
+		},
+		{
+			"Name": "POLine",
+			"ParentRecordName": "POBatch",
+			"MatchExpression": "^L",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"},
+				{"Name": "Qty", "TypeName": "Number", "FieldType": {"ConvertToDecimalPlaces": 1}}
+			]
+		},
+		{
+			"Name": "POTrailer",
+			"ParentRecordName": "POBatch",
+			"MatchExpression": "^T",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"ControlTotals": [
+				{"FieldName": "TotalQty", "RecordName": "POLine", "SumFieldName": "Qty", "ResetOnRecordName": "POBatch"},
+				{"FieldName": "LineCount", "RecordName": "POLine", "ResetOnRecordName": "POBatch"}
+			],
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"},
+				{"Name": "TotalQty", "TypeName": "Number", "FieldType": {"ConvertToDecimalPlaces": 1}},
+				{"Name": "LineCount", "TypeName": "Number"}
+			]
+		}
+	]
+}
+`
+
+func TestControlTotals(t *testing.T) {
+	data := strings.Join([]string{
+		"B",
+		"L,11",
+		"L,22",
+		"T,33,2",
+		"B",
+		"L,5",
+		"T,6,2",
+	}, "\n")
+	errs := make([]error, 0)
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(controlTotalCfg)),
+		func(record *Record) error { return nil },
+		func(err error) error {
+			if err != nil {
+				errs = append(errs, err)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 ControlTotalErrors, got %v", errs)
+	}
+	var cte ControlTotalError
+	if !errors.As(errs[0], &cte) || cte.FieldName != "TotalQty" || cte.LineNum != 7 || cte.Expected != 0.6 || cte.Actual != 0.5 {
+		t.Errorf("Unexpected TotalQty error %v", errs[0])
+	}
+	if !errors.As(errs[1], &cte) || cte.FieldName != "LineCount" || cte.Expected != 2 || cte.Actual != 1 {
+		t.Errorf("Unexpected LineCount error %v", errs[1])
+	}
+}
+
+func TestValidateControlTotals(t *testing.T) {
+	cfg := strings.Replace(controlTotalCfg, `"SumFieldName": "Qty"`, `"SumFieldName": "Quantity"`, 1)
+	_, err := NewParser(ioutil.NopCloser(strings.NewReader(cfg)), nil, nil)
+	var ces ConfigurationErrors
+	if !errors.As(err, &ces) || len(ces) != 1 {
+		t.Errorf("Expected 1 ConfigurationError, got %v", err)
+	}
+}
@@ -0,0 +1,557 @@
+package sfr
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//ParseCopybook reads a COBOL copybook & returns a RecordDefinition for each 01
+//(or 77) level record it describes. Elementary items become FieldDefinitions
+//read by a FixedWidth RecordReader. PIC X & A items (and numeric edited items)
+//are String fields, PIC 9 display items are Number fields, PIC S9 display items
+//are ZonedDecimal fields, COMP items are Binary fields & COMP-3 items are
+//PackedDecimal fields, each with implied decimals (V) mapped to
+//ConvertToDecimalPlaces. Other items (COMP-1, COMP-2, SIGN TRAILING SEPARATE
+//etc.) keep their storage size but are read as String fields. Group items are
+//flattened into their elementary items & OCCURS clauses repeat items with COBOL
+//style subscripts (for example "AMOUNT(2)"). REDEFINES below the 01 level are
+//skipped as their storage is covered by the item they redefine, whereas 01
+//level REDEFINES produce an alternative RecordDefinition. FILLER items are
+//skipped. Names used more than once are qualified (for example "AMOUNT OF
+//TOTALS"). Fixed-format sequence & indicator areas, comments & 88 level
+//condition names are ignored. MatchExpressions are not generated so must be
+//added to the configuration when a copybook describes more than one record.
+func ParseCopybook(r io.Reader) ([]*RecordDefinition, error) {
+	rawDefs, err := copybookConfigs(r)
+	if err != nil {
+		return nil, err
+	}
+	return recordDefinitionsFromConfig(rawDefs)
+}
+
+//WriteCopybookConfig reads a COBOL copybook from r & writes the equivalent
+//Parser JSON configuration to w so that it can be reviewed & checked in. If
+//splitOnRecordName is empty the first record is used.
+func WriteCopybookConfig(w io.Writer, r io.Reader, splitOnRecordName string) error {
+	rawDefs, err := copybookConfigs(r)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(parserConfig(splitOnRecordName, rawDefs))
+}
+
+//copybookItem is a data description entry read from a copybook.
+type copybookItem struct {
+	lineNum      int
+	level        int
+	name         string
+	picture      string
+	usage        string
+	occurs       int
+	redefines    string
+	signLeading  bool
+	signSeparate bool
+	parent       *copybookItem
+	children     []*copybookItem
+}
+
+//copybookToken is a word from a copybook & the line it was read from.
+type copybookToken struct {
+	text    string
+	lineNum int
+}
+
+//copybookConfigs returns the JSON configuration of each RecordDefinition
+//described by the copybook.
+func copybookConfigs(r io.Reader) ([]map[string]interface{}, error) {
+	statements, err := copybookStatements(r)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*copybookItem, 0)
+	var last *copybookItem
+	for _, statement := range statements {
+		item, err := parseCopybookItem(statement)
+		if err != nil {
+			return nil, err
+		}
+		if item == nil {
+			continue
+		}
+		if item.level == 1 || item.level == 77 {
+			records = append(records, item)
+			last = item
+			continue
+		}
+		if last == nil {
+			return nil, copybookError(item.lineNum, "Level %02d item \"%s\" is not within an 01 level record", item.level, item.name)
+		}
+		//Find the parent: the closest preceding item with a lower level.
+		parent := last
+		for parent != nil && parent.level >= item.level {
+			parent = parent.parent
+		}
+		if parent == nil {
+			return nil, copybookError(item.lineNum, "Level %02d item \"%s\" is not within an 01 level record", item.level, item.name)
+		}
+		item.parent = parent
+		parent.children = append(parent.children, item)
+		last = item
+	}
+	if len(records) == 0 {
+		return nil, ConfigurationError(fmt.Errorf("No 01 level records found in copybook"))
+	}
+
+	rawDefs := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		b := copybookBuilder{names: make(map[string]map[*copybookItem]bool)}
+		if _, err := b.layout(record, 0, nil); err != nil {
+			return nil, err
+		}
+		b.qualifyNames()
+		fldDefs := make([]map[string]interface{}, len(b.fields))
+		coords := make([]FixedWidthFieldCoordinate, len(b.fields))
+		for i, fld := range b.fields {
+			fldDefs[i] = map[string]interface{}{
+				"Name":      fld.fullName(),
+				"TypeName":  fld.typeName,
+				"FieldType": fld.fieldType,
+			}
+			coords[i] = fld.coord
+		}
+		rawDefs = append(rawDefs, map[string]interface{}{
+			"Name":             record.name,
+			"ReaderName":       fixedWidthRecordReaderName,
+			"RecordReader":     FixedWidthRecordReader{Coordinates: coords},
+			"FieldDefinitions": fldDefs,
+		})
+	}
+	return rawDefs, nil
+}
+
+//copybookStatements splits a copybook into statements (ending in a period
+//followed by a space or the end of the copybook), ignoring comments and the
+//fixed-format sequence (1-6), indicator (7) & identification (73-80) areas.
+func copybookStatements(r io.Reader) ([][]copybookToken, error) {
+	scanner := bufio.NewScanner(r)
+	statements := make([][]copybookToken, 0)
+	statement := make([]copybookToken, 0)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if isFixedFormat(line) {
+			if line[6] == '*' || line[6] == '/' {
+				continue
+			}
+			if len(line) > 72 {
+				line = line[:72]
+			}
+			line = line[7:]
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "*") {
+			continue
+		}
+		for _, word := range splitCopybookWords(line) {
+			end := strings.HasSuffix(word, ".")
+			if end && (word[0] == '\'' || word[0] == '"') {
+				//A quoted literal ends the statement if the period follows the closing quote.
+				end = len(word) > 2 && strings.LastIndexByte(word, word[0]) == len(word)-2
+			}
+			if end {
+				word = strings.TrimSuffix(word, ".")
+			}
+			if word != "" {
+				statement = append(statement, copybookToken{text: word, lineNum: lineNum})
+			}
+			if end && len(statement) > 0 {
+				statements = append(statements, statement)
+				statement = make([]copybookToken, 0)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(statement) > 0 {
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+//isFixedFormat returns true if line has a sequence area of digits or spaces
+//followed by an indicator area.
+func isFixedFormat(line string) bool {
+	if len(line) < 7 {
+		return false
+	}
+	for _, c := range line[:6] {
+		if c != ' ' && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return line[6] == ' ' || line[6] == '*' || line[6] == '/' || line[6] == '-'
+}
+
+//splitCopybookWords splits a line on spaces, keeping quoted literals together.
+func splitCopybookWords(line string) []string {
+	words := make([]string, 0)
+	var word strings.Builder
+	var quote rune
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			word.WriteRune(c)
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			word.WriteRune(c)
+			quote = c
+		case c == ' ' || c == '\t':
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(c)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	//Commas & semicolons followed by a space are separators.
+	for i, w := range words {
+		if w[0] != '\'' && w[0] != '"' {
+			words[i] = strings.TrimRight(w, ",;")
+		}
+	}
+	return words
+}
+
+//parseCopybookItem parses a data description entry. It returns nil for
+//entries which describe no storage (66 & 88 levels).
+func parseCopybookItem(tokens []copybookToken) (*copybookItem, error) {
+	lineNum := tokens[0].lineNum
+	level, err := strconv.Atoi(tokens[0].text)
+	if err != nil {
+		return nil, copybookError(lineNum, "Expected a level number, got \"%s\"", tokens[0].text)
+	}
+	if level == 66 || level == 88 {
+		return nil, nil
+	}
+	if (level < 1 || level > 49) && level != 77 {
+		return nil, copybookError(lineNum, "Invalid level number %d", level)
+	}
+	item := &copybookItem{lineNum: lineNum, level: level, name: "FILLER", usage: "DISPLAY"}
+	words := make([]string, len(tokens)-1)
+	for i, token := range tokens[1:] {
+		words[i] = strings.ToUpper(token.text)
+	}
+	if len(words) > 0 && !isCopybookKeyword(words[0]) {
+		item.name = tokens[1].text
+		words = words[1:]
+	}
+	for i := 0; i < len(words); i++ {
+		//next returns the following word, skipping optional noise words.
+		next := func(noise ...string) string {
+			for i+1 < len(words) {
+				i++
+				isNoise := false
+				for _, n := range noise {
+					if words[i] == n {
+						isNoise = true
+					}
+				}
+				if !isNoise {
+					return words[i]
+				}
+			}
+			return ""
+		}
+		switch words[i] {
+		case "PIC", "PICTURE":
+			item.picture = next("IS")
+		case "REDEFINES":
+			item.redefines = next()
+		case "OCCURS":
+			occurs, err := strconv.Atoi(next())
+			if err != nil {
+				return nil, copybookError(lineNum, "Invalid OCCURS clause for \"%s\"", item.name)
+			}
+			if i+1 < len(words) && words[i+1] == "TO" {
+				//Variable occurrences use the maximum.
+				i++
+				if occurs, err = strconv.Atoi(next()); err != nil {
+					return nil, copybookError(lineNum, "Invalid OCCURS clause for \"%s\"", item.name)
+				}
+			}
+			item.occurs = occurs
+		case "USAGE":
+			item.usage = normaliseUsage(next("IS"))
+		case "COMP", "COMPUTATIONAL", "COMP-1", "COMPUTATIONAL-1", "COMP-2", "COMPUTATIONAL-2",
+			"COMP-3", "COMPUTATIONAL-3", "COMP-4", "COMPUTATIONAL-4", "COMP-5", "COMPUTATIONAL-5",
+			"BINARY", "PACKED-DECIMAL", "DISPLAY":
+			item.usage = normaliseUsage(words[i])
+		case "LEADING":
+			item.signLeading = true
+		case "SEPARATE":
+			item.signSeparate = true
+		case "VALUE", "VALUES":
+			//Skip the literal.
+			next("IS", "ARE", "ALL")
+		}
+	}
+	return item, nil
+}
+
+//isCopybookKeyword returns true if word starts a clause rather than naming an item.
+func isCopybookKeyword(word string) bool {
+	switch word {
+	case "PIC", "PICTURE", "REDEFINES", "OCCURS", "USAGE", "VALUE", "VALUES", "SIGN",
+		"COMP", "COMP-3", "BINARY", "PACKED-DECIMAL", "DISPLAY":
+		return true
+	}
+	return false
+}
+
+//normaliseUsage maps usage synonyms onto DISPLAY, COMP, COMP-1, COMP-2 & COMP-3.
+func normaliseUsage(usage string) string {
+	usage = strings.Replace(usage, "COMPUTATIONAL", "COMP", 1)
+	switch usage {
+	case "BINARY", "COMP-4", "COMP-5":
+		return "COMP"
+	case "PACKED-DECIMAL":
+		return "COMP-3"
+	}
+	return usage
+}
+
+//copybookPicture describes a PICTURE string.
+type copybookPicture struct {
+	//length is the number of character positions.
+	length   int
+	digits   int
+	decimals int
+	signed   bool
+	//numeric is set for pictures containing only S, 9, V & P.
+	numeric bool
+}
+
+//parsePicture analyses a PICTURE string such as "S9(7)V99" or "X(10)".
+func parsePicture(pic string) (cp copybookPicture, err error) {
+	pic = strings.ToUpper(pic)
+	cp.numeric = true
+	afterV := false
+	for i := 0; i < len(pic); i++ {
+		c := pic[i]
+		count := 1
+		if i+1 < len(pic) && pic[i+1] == '(' {
+			end := strings.IndexByte(pic[i:], ')')
+			if end < 0 {
+				return cp, fmt.Errorf("Invalid PICTURE \"%s\"", pic)
+			}
+			if count, err = strconv.Atoi(pic[i+2 : i+end]); err != nil {
+				return cp, fmt.Errorf("Invalid PICTURE \"%s\"", pic)
+			}
+			i += end
+		}
+		switch c {
+		case '9':
+			cp.digits += count
+			cp.length += count
+			if afterV {
+				cp.decimals += count
+			}
+		case 'S':
+			cp.signed = true
+		case 'V':
+			afterV = true
+		case 'P':
+			//Scaling positions take no storage.
+		case 'X', 'A', 'Z', '*', '+', '-', '.', ',', 'B', '0', '/', '$', 'C', 'R', 'D', 'E':
+			cp.numeric = false
+			cp.length += count
+		case 'N', 'G':
+			//National & DBCS characters take 2 bytes.
+			cp.numeric = false
+			cp.length += 2 * count
+		default:
+			return cp, fmt.Errorf("Invalid PICTURE \"%s\"", pic)
+		}
+	}
+	if cp.digits == 0 {
+		cp.numeric = false
+	}
+	return cp, nil
+}
+
+//copybookField is an elementary item positioned within a record.
+type copybookField struct {
+	item       *copybookItem
+	name       string
+	subscripts []int
+	typeName   string
+	fieldType  map[string]interface{}
+	coord      FixedWidthFieldCoordinate
+}
+
+//fullName returns the name of the field followed by its subscripts.
+func (fld copybookField) fullName() string {
+	if len(fld.subscripts) == 0 {
+		return fld.name
+	}
+	subs := make([]string, len(fld.subscripts))
+	for i, sub := range fld.subscripts {
+		subs[i] = strconv.Itoa(sub)
+	}
+	return fmt.Sprintf("%s(%s)", fld.name, strings.Join(subs, ","))
+}
+
+//copybookBuilder lays out the elementary items of a record.
+type copybookBuilder struct {
+	fields []copybookField
+	//names holds the items using each name.
+	names map[string]map[*copybookItem]bool
+}
+
+//layout adds the fields of item at offset & returns the size of item.
+//subscripts holds the occurrence numbers of enclosing OCCURS items.
+func (b *copybookBuilder) layout(item *copybookItem, offset int, subscripts []int) (int, error) {
+	occurs := item.occurs
+	if occurs == 0 {
+		occurs = 1
+	}
+	size := 0
+	for occurrence := 1; occurrence <= occurs; occurrence++ {
+		itemSubscripts := subscripts
+		if item.occurs > 0 {
+			itemSubscripts = append(append([]int(nil), subscripts...), occurrence)
+		}
+		var itemSize int
+		var err error
+		if len(item.children) > 0 {
+			for _, child := range item.children {
+				if child.redefines != "" {
+					continue
+				}
+				childSize, err := b.layout(child, offset+size+itemSize, itemSubscripts)
+				if err != nil {
+					return 0, err
+				}
+				itemSize += childSize
+			}
+		} else if itemSize, err = b.addField(item, offset+size, itemSubscripts); err != nil {
+			return 0, err
+		}
+		size += itemSize
+	}
+	return size, nil
+}
+
+//addField adds an elementary item as a field & returns its storage size.
+func (b *copybookBuilder) addField(item *copybookItem, offset int, subscripts []int) (int, error) {
+	var pic copybookPicture
+	if item.picture != "" {
+		var err error
+		if pic, err = parsePicture(item.picture); err != nil {
+			return 0, copybookError(item.lineNum, "%s for \"%s\"", err, item.name)
+		}
+	} else if item.usage != "COMP-1" && item.usage != "COMP-2" {
+		return 0, copybookError(item.lineNum, "Elementary item \"%s\" has no PICTURE", item.name)
+	}
+	size, err := storageSize(item, pic)
+	if err != nil {
+		return 0, err
+	}
+	if strings.EqualFold(item.name, "FILLER") {
+		return size, nil
+	}
+	fld := copybookField{
+		item:       item,
+		name:       item.name,
+		subscripts: subscripts,
+		typeName:   "String",
+		fieldType:  map[string]interface{}{},
+		coord:      FixedWidthFieldCoordinate{Start: offset, End: offset + size},
+	}
+	switch {
+	case item.usage == "COMP":
+		fld.typeName = "Binary"
+		fld.fieldType["ConvertToDecimalPlaces"] = pic.decimals
+		fld.fieldType["Signed"] = pic.signed
+	case item.usage == "COMP-3":
+		fld.typeName = "PackedDecimal"
+		fld.fieldType["ConvertToDecimalPlaces"] = pic.decimals
+	case item.usage != "DISPLAY" || !pic.numeric:
+	case pic.signed && !item.signSeparate:
+		fld.typeName = "ZonedDecimal"
+		fld.fieldType["ConvertToDecimalPlaces"] = pic.decimals
+	case !pic.signed || item.signLeading:
+		fld.typeName = "Number"
+		fld.fieldType["ConvertToDecimalPlaces"] = pic.decimals
+		fld.coord.Justify = "Right"
+		fld.coord.Pad = "0"
+	}
+	name := strings.ToUpper(item.name)
+	if b.names[name] == nil {
+		b.names[name] = make(map[*copybookItem]bool)
+	}
+	b.names[name][item] = true
+	b.fields = append(b.fields, fld)
+	return size, nil
+}
+
+//storageSize returns the number of bytes an elementary item occupies.
+func storageSize(item *copybookItem, pic copybookPicture) (int, error) {
+	switch item.usage {
+	case "DISPLAY":
+		if pic.signed && item.signSeparate {
+			return pic.length + 1, nil
+		}
+		return pic.length, nil
+	case "COMP":
+		switch {
+		case pic.digits <= 4:
+			return 2, nil
+		case pic.digits <= 9:
+			return 4, nil
+		default:
+			return 8, nil
+		}
+	case "COMP-1":
+		return 4, nil
+	case "COMP-2":
+		return 8, nil
+	case "COMP-3":
+		return pic.digits/2 + 1, nil
+	}
+	return 0, copybookError(item.lineNum, "Unsupported USAGE \"%s\" for \"%s\"", item.usage, item.name)
+}
+
+//qualifyNames qualifies fields whose names are used more than once with the
+//names of their enclosing groups.
+func (b *copybookBuilder) qualifyNames() {
+	for i, fld := range b.fields {
+		if len(b.names[strings.ToUpper(fld.item.name)]) < 2 {
+			continue
+		}
+		for parent := fld.item.parent; parent != nil && parent.parent != nil; parent = parent.parent {
+			if !strings.EqualFold(parent.name, "FILLER") {
+				b.fields[i].name = fmt.Sprintf("%s OF %s", fld.name, parent.name)
+				break
+			}
+		}
+	}
+}
+
+//copybookError returns a ConfigurationError for a line of a copybook.
+func copybookError(lineNum int, format string, args ...interface{}) error {
+	return ConfigurationError(fmt.Errorf("Copybook line %d: %s", lineNum, fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,109 @@
+package sfr
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const testCopybook = `      * Customer account record.
+000100 01  CUSTOMER-RECORD.                                             CUST0001
+000200     05  CUST-ID             PIC 9(6).                            CUST0002
+000300     05  CUST-NAME           PIC X(20).
+000400     05  FILLER              PIC X(2).
+000500     05  BALANCE             PIC S9(5)V99.
+000600     05  BALANCE-X REDEFINES BALANCE PIC X(7).
+000700     05  STATUS-CODE         PIC X.
+000800         88  ACTIVE          VALUE 'A'.
+000900     05  MONTHLY-TOTALS OCCURS 2 TIMES.
+001000         10  AMOUNT          PIC 9(3)V9 USAGE IS DISPLAY.
+001100         10  PACKED-AMOUNT   PIC S9(5) COMP-3.
+001200     05  YEAR-TOTALS.
+001300         10  AMOUNT          PIC 9(4).
+001400 01  CUSTOMER-TRAILER REDEFINES CUSTOMER-RECORD.
+001500     05  RECORD-COUNT        PIC 9(8) COMP.
+`
+
+func TestParseCopybook(t *testing.T) {
+	recDefs, err := ParseCopybook(strings.NewReader(testCopybook))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recDefs) != 2 || recDefs[0].Name != "CUSTOMER-RECORD" || recDefs[1].Name != "CUSTOMER-TRAILER" {
+		t.Fatalf("Unexpected RecordDefinitions %+v", recDefs)
+	}
+	expected := []struct {
+		name       string
+		typeName   string
+		start, end int
+	}{
+		{"CUST-ID", "Number", 0, 6},
+		{"CUST-NAME", "String", 6, 26},
+		{"BALANCE", "ZonedDecimal", 28, 35},
+		{"STATUS-CODE", "String", 35, 36},
+		{"AMOUNT OF MONTHLY-TOTALS(1)", "Number", 36, 40},
+		{"PACKED-AMOUNT(1)", "PackedDecimal", 40, 43},
+		{"AMOUNT OF MONTHLY-TOTALS(2)", "Number", 43, 47},
+		{"PACKED-AMOUNT(2)", "PackedDecimal", 47, 50},
+		{"AMOUNT OF YEAR-TOTALS", "Number", 50, 54},
+	}
+	fldDefs := recDefs[0].FieldDefinitions
+	coords := recDefs[0].RecordReader.(FixedWidthRecordReader).Coordinates
+	if len(fldDefs) != len(expected) {
+		t.Fatalf("Expected %d fields, got %d", len(expected), len(fldDefs))
+	}
+	for i, exp := range expected {
+		if fldDefs[i].Name != exp.name || fldDefs[i].TypeName != exp.typeName || coords[i].Start != exp.start || coords[i].End != exp.end {
+			t.Errorf("Field %d: expected %+v, got %+v %+v", i, exp, fldDefs[i], coords[i])
+		}
+	}
+	if coords[1].End-coords[1].Start != 20 || recDefs[1].RecordReader.(FixedWidthRecordReader).Coordinates[0].End != 4 {
+		t.Error("Unexpected CUSTOMER-TRAILER coordinates")
+	}
+
+	//Implied decimals.
+	vals, err := recDefs[0].RecordReader.Read([]byte("000042Fred Bloggs         xx0012345A" + strings.Repeat("0", 18)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := fldDefs[2].FieldType.GetValue(vals[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 123.45 {
+		t.Errorf("Expected BALANCE 123.45, got %v", balance)
+	}
+}
+
+func TestWriteCopybookConfig(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCopybookConfig(&buf, strings.NewReader(testCopybook), ""); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewParser(ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.SplitOnRecordName != "CUSTOMER-RECORD" || len(p.RecordDefinitions) != 2 {
+		t.Errorf("Unexpected Parser %+v", p)
+	}
+	var config map[string]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseCopybookErrors(t *testing.T) {
+	for _, copybook := range []string{
+		"05 ORPHAN PIC X.",
+		"01 REC. 05 BAD PIC X(3.",
+		"01 REC. 05 NOPIC.",
+		"* Comment only",
+	} {
+		if _, err := ParseCopybook(strings.NewReader(copybook)); err == nil {
+			t.Errorf("Expected an error for %q", copybook)
+		}
+	}
+}
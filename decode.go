@@ -0,0 +1,197 @@
+package sfr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+//Decode copies the Fields & Children of the Record into the struct pointed to
+//by v. Struct fields are mapped using "sfr" tags:
+//	PONumber string   `sfr:"PONumber"`    - the value of the Field named PONumber.
+//	Lines    []POLine `sfr:"child=POLine"` - each child Record named POLine.
+//Fields without a tag are ignored. Child records may be decoded into a slice
+//of structs or struct pointers, or into a single struct or struct pointer (in
+//which case the first matching child is used).
+//Field values are converted to the type of the struct field: float64 values
+//to any numeric type, string values to strings, numbers & bools (using strconv)
+//and time.Time values to time.Time. A FieldParseError naming the Record & Field
+//is returned if a value cannot be converted.
+func (rec *Record) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Decode requires a non-nil pointer to a struct, got %T", v)
+	}
+	return rec.decodeStruct(rv.Elem())
+}
+
+//decodeStruct decodes the Record into the struct value target.
+func (rec *Record) decodeStruct(target reflect.Value) error {
+	targetType := target.Type()
+	for i := 0; i < targetType.NumField(); i++ {
+		structFld := targetType.Field(i)
+		tag, ok := structFld.Tag.Lookup("sfr")
+		if !ok || tag == "-" || structFld.PkgPath != "" {
+			//Untagged or unexported fields are skipped.
+			continue
+		}
+		if strings.HasPrefix(tag, "child=") {
+			if err := rec.decodeChildren(strings.TrimPrefix(tag, "child="), target.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+		fld, err := rec.GetField(tag)
+		if err != nil {
+			return FieldParseError{RecordName: rec.Name, FieldName: tag, Text: "Field not found in Record"}
+		}
+		if err = assignValue(fld.Value, target.Field(i)); err != nil {
+			return FieldParseError{
+				RecordName: rec.Name,
+				FieldName:  tag,
+				Text:       fmt.Sprintf("Cannot decode into %s (%s): %s", structFld.Name, structFld.Type, err),
+			}
+		}
+	}
+	return nil
+}
+
+//decodeChildren decodes the child Records named childName into target.
+func (rec *Record) decodeChildren(childName string, target reflect.Value) error {
+	children := make([]*Record, 0)
+	for _, child := range rec.Children {
+		if child.Name == childName {
+			children = append(children, child)
+		}
+	}
+	if target.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(target.Type(), len(children), len(children))
+		for i, child := range children {
+			if err := child.decodeInto(slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		target.Set(slice)
+		return nil
+	}
+	if len(children) == 0 {
+		return nil
+	}
+	return children[0].decodeInto(target)
+}
+
+//decodeInto decodes the Record into a struct or struct pointer value.
+func (rec *Record) decodeInto(target reflect.Value) error {
+	if target.Kind() == reflect.Ptr {
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		target = target.Elem()
+	}
+	if target.Kind() != reflect.Struct {
+		return RecordParseError{RecordName: rec.Name, Text: fmt.Sprintf("Cannot decode child Record into %s", target.Type())}
+	}
+	return rec.decodeStruct(target)
+}
+
+//assignValue converts a Field value into the type of target & sets it.
+func assignValue(value interface{}, target reflect.Value) error {
+	if value == nil {
+		return nil
+	}
+	if target.Kind() == reflect.Ptr {
+		elem := reflect.New(target.Type().Elem())
+		if err := assignValue(value, elem.Elem()); err != nil {
+			return err
+		}
+		target.Set(elem)
+		return nil
+	}
+	rv := reflect.ValueOf(value)
+	if target.Kind() == reflect.Interface || target.Type() == timeType {
+		if !rv.Type().AssignableTo(target.Type()) {
+			return fmt.Errorf("Cannot assign %T", value)
+		}
+		target.Set(rv)
+		return nil
+	}
+	switch val := value.(type) {
+	case float64:
+		return assignFloat(val, target)
+	case string:
+		return assignString(val, target)
+	}
+	if rv.Type().ConvertibleTo(target.Type()) {
+		target.Set(rv.Convert(target.Type()))
+		return nil
+	}
+	return fmt.Errorf("Cannot convert %T", value)
+}
+
+//assignFloat sets a numeric target from a float64, checking it fits.
+func assignFloat(val float64, target reflect.Value) error {
+	switch target.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if target.OverflowFloat(val) {
+			return fmt.Errorf("%v overflows", val)
+		}
+		target.SetFloat(val)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		//The range is checked before converting as converting a float64 outside
+		//the range of int64 gives an undefined result (NaN fails val == Trunc).
+		if val != math.Trunc(val) || val < math.MinInt64 || val >= math.MaxInt64 || target.OverflowInt(int64(val)) {
+			return fmt.Errorf("%v is not a valid %s", val, target.Type())
+		}
+		target.SetInt(int64(val))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if val != math.Trunc(val) || val < 0 || val >= math.MaxUint64 || target.OverflowUint(uint64(val)) {
+			return fmt.Errorf("%v is not a valid %s", val, target.Type())
+		}
+		target.SetUint(uint64(val))
+	case reflect.String:
+		target.SetString(strconv.FormatFloat(val, 'f', -1, 64))
+	default:
+		return fmt.Errorf("Cannot convert float64")
+	}
+	return nil
+}
+
+//assignString sets a target from a string, parsing it if the target is not a string.
+func assignString(val string, target reflect.Value) error {
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(val)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetFloat(f)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(strings.TrimSpace(val), 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(strings.TrimSpace(val), 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetUint(u)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(val))
+		if err != nil {
+			return err
+		}
+		target.SetBool(b)
+	default:
+		return fmt.Errorf("Cannot convert string")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package sfr
+
+import (
+	"errors"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+type testShipment struct {
+	Quantity     int       `sfr:"Quantity"`
+	DeliveryDate time.Time `sfr:"DeliveryDate"`
+}
+
+type testPOLine struct {
+	LineNumber int             `sfr:"LineNumber"`
+	PartNumber string          `sfr:"PartNumber"`
+	UnitPrice  float64         `sfr:"UnitPrice"`
+	Shipments  []*testShipment `sfr:"child=POShipment"`
+}
+
+type testPO struct {
+	PONumber   string       `sfr:"PONumber"`
+	VendorName *string      `sfr:"VendorName"`
+	Lines      []testPOLine `sfr:"child=POLine"`
+	Ignored    string
+}
+
+func TestDecode(t *testing.T) {
+	config, err := os.Open("testfiles/DelimitedPurchaseOrder/po.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := make([]testPO, 0)
+	p, err := NewParser(
+		config,
+		func(record *Record) error {
+			var po testPO
+			if err := record.Decode(&po); err != nil {
+				return err
+			}
+			pos = append(pos, po)
+			return nil
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.ParseFile("testfiles", "DelimitedPurchaseOrder", "po.dat"); err != nil {
+		t.Fatal(err)
+	}
+	if len(pos) != 3 {
+		t.Fatalf("Expected 3 POs, got %d", len(pos))
+	}
+	po := pos[0]
+	if po.PONumber != "1000000001" || *po.VendorName != "Adam, Brian & Charles Ltd." {
+		t.Errorf("Unexpected PO header %+v", po)
+	}
+	if len(po.Lines) != 2 || po.Lines[1].LineNumber != 3 || po.Lines[1].UnitPrice != 33.75 {
+		t.Fatalf("Unexpected PO lines %+v", po.Lines)
+	}
+	shipment := po.Lines[0].Shipments[1]
+	if shipment.Quantity != 50 || !shipment.DeliveryDate.Equal(time.Date(2019, 8, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected shipment %+v", shipment)
+	}
+}
+
+func TestDecodeMismatch(t *testing.T) {
+	rec := &Record{
+		Name:   "POLine",
+		Fields: []Field{{Name: "UnitPrice", TypeName: "Number", Value: 2.5}},
+	}
+	var target struct {
+		UnitPrice int `sfr:"UnitPrice"`
+	}
+	err := rec.Decode(&target)
+	var fldErr FieldParseError
+	if !errors.As(err, &fldErr) || fldErr.RecordName != "POLine" || fldErr.FieldName != "UnitPrice" {
+		t.Errorf("Expected a FieldParseError for POLine.UnitPrice, got %v", err)
+	}
+}
+
+func TestDecodeOutOfRange(t *testing.T) {
+	tests := []struct {
+		value       float64
+		validInt64  bool
+		validUint64 bool
+	}{
+		{1e20, false, false},
+		{-1e20, false, false},
+		{math.Inf(1), false, false},
+		{math.NaN(), false, false},
+		{math.Ldexp(1, 63), false, true},
+		{-math.Ldexp(1, 63), true, false},
+	}
+	for _, test := range tests {
+		rec := &Record{
+			Name:   "POLine",
+			Fields: []Field{{Name: "Quantity", TypeName: "Number", Value: test.value}},
+		}
+		var signed struct {
+			Quantity int64 `sfr:"Quantity"`
+		}
+		if err := rec.Decode(&signed); (err == nil) != test.validInt64 {
+			t.Errorf("%v as an int64: got %d (%v)", test.value, signed.Quantity, err)
+		}
+		var unsigned struct {
+			Quantity uint64 `sfr:"Quantity"`
+		}
+		if err := rec.Decode(&unsigned); (err == nil) != test.validUint64 {
+			t.Errorf("%v as a uint64: got %d (%v)", test.value, unsigned.Quantity, err)
+		}
+	}
+}
@@ -54,18 +54,70 @@ type RecordDefinition struct {
 	ReaderName       string
 	RecordReader     RecordReader
 	ParentRecordName string
-	FieldDefinitions []FieldDefinition
+	//JoinOnFieldNames attaches Records to the parent Record whose fields named
+	//ParentJoinOnFieldNames (defaulting to JoinOnFieldNames) have the same values
+	//rather than to the last parent Record read.
+	JoinOnFieldNames       []string
+	ParentJoinOnFieldNames []string
+	FieldDefinitions       []FieldDefinition
+	//Encoding overrides the Parser's Encoding for this RecordDefinition.
+	Encoding string
+	//ControlTotals are checked against the values accumulated from earlier
+	//Records each time a Record of this RecordDefinition is read.
+	ControlTotals []ControlTotal
+	//MinOccurs & MaxOccurs limit the number of Records of this RecordDefinition
+	//beneath each parent Record. A MaxOccurs of 0 is unlimited.
+	MinOccurs int
+	MaxOccurs int
+	//matchRegexp is the compiled MatchExpression.
+	matchRegexp *regexp.Regexp
+}
+
+//Compile compiles the MatchExpression so that it is not recompiled for each
+//record. It is called when a RecordDefinition is unmarshalled & by Validate.
+func (rd *RecordDefinition) Compile() error {
+	re, err := rd.compiled()
+	if err != nil {
+		return err
+	}
+	rd.matchRegexp = re
+	return nil
+}
+
+//compiled returns the compiled MatchExpression without storing it.
+func (rd *RecordDefinition) compiled() (*regexp.Regexp, error) {
+	re, err := compileRegexp(rd.MatchExpression, rd.matchRegexp)
+	if err != nil {
+		return nil, ConfigurationError(fmt.Errorf("Invalid MatchExpression in RecordDefinition \"%s\": %s", rd.Name, err))
+	}
+	return re, nil
+}
+
+//compileRegexp returns expr compiled (nil if expr is empty). cached is returned
+//instead if it was compiled from expr, so that an expression changed since it
+//was compiled is never used stale.
+func compileRegexp(expr string, cached *regexp.Regexp) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	if cached != nil && cached.String() == expr {
+		return cached, nil
+	}
+	return regexp.Compile(expr)
 }
 
 //Match matches the current record against the regular expression configured
 //for this RecordDefinition. If a regexp is configured in the RecordDefinition,
-//it returns the result of regexp.Match. If no regexp is configured in the
-//RecordDefinitnion, it always returns true.
+//it returns the result of matching the compiled regexp. If no regexp is
+//configured in the RecordDefinitnion, it always returns true. Match does not
+//modify the RecordDefinition so it may be shared by concurrent Parsers (the
+//Parser compiles each MatchExpression once before reading the source).
 func (rd *RecordDefinition) Match(data []byte) (bool, error) {
-	if rd.MatchExpression == "" {
-		return true, nil
+	re, err := rd.compiled()
+	if err != nil || re == nil {
+		return err == nil, err
 	}
-	return regexp.Match(rd.MatchExpression, data)
+	return re.Match(data), nil
 }
 
 //UnmarshalJSON unmarshals a RecordDefinition from JSON.
@@ -89,6 +141,10 @@ func (rd *RecordDefinition) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
+	err = rd.Compile()
+	if err != nil {
+		return err
+	}
 
 	//recordReader
 	var readerName string
@@ -117,6 +173,40 @@ func (rd *RecordDefinition) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	//JoinOnFieldNames
+	err = unmarshalStrings(rawRecDef, "JoinOnFieldNames", &rd.JoinOnFieldNames)
+	if err != nil {
+		return err
+	}
+	err = unmarshalStrings(rawRecDef, "ParentJoinOnFieldNames", &rd.ParentJoinOnFieldNames)
+	if err != nil {
+		return err
+	}
+
+	//Encoding
+	err = unmarshalString(rawRecDef, "Encoding", &rd.Encoding)
+	if err != nil {
+		return err
+	}
+
+	//MinOccurs & MaxOccurs
+	err = unmarshalInt(rawRecDef, "MinOccurs", &rd.MinOccurs)
+	if err != nil {
+		return err
+	}
+	err = unmarshalInt(rawRecDef, "MaxOccurs", &rd.MaxOccurs)
+	if err != nil {
+		return err
+	}
+
+	//ControlTotals
+	if rawControlTotals, ok := rawRecDef["ControlTotals"]; ok {
+		err = json.Unmarshal(rawControlTotals, &rd.ControlTotals)
+		if err != nil {
+			return err
+		}
+	}
+
 	//FieldDefinitions
 	if rawFieldDefinitions, ok := rawRecDef["FieldDefinitions"]; ok {
 		err = json.Unmarshal(rawFieldDefinitions, &rd.FieldDefinitions)
@@ -132,6 +222,19 @@ type FieldDefinition struct {
 	Name      string
 	TypeName  string
 	FieldType FieldType
+	//Required, Pattern, MinLength, MaxLength & Enum check the text of the Field
+	//(with surrounding spaces removed) & Min & Max check Number values once the
+	//value has been read (see FieldRule). Blank Fields are only checked by
+	//Required.
+	Required  bool
+	Pattern   string
+	MinLength int
+	MaxLength int
+	Enum      []string
+	Min       *float64
+	Max       *float64
+	//patternRegexp is the compiled Pattern.
+	patternRegexp *regexp.Regexp
 }
 
 //UnmarshalJSON builds a FieldDefinition using a registered FieldTypeUnmarshalFunc.
@@ -159,11 +262,39 @@ func (def *FieldDefinition) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("Error getting field type unmarshal function for FieldType \"%s\": %s", def.Name, err)
 	}
 	def.FieldType, err = typeFunc(rawType)
+	if err != nil {
+		return err
+	}
 
-	return err
+	//Validation rules
+	var rules struct {
+		Required  bool
+		Pattern   string
+		MinLength int
+		MaxLength int
+		Enum      []string
+		Min       *float64
+		Max       *float64
+	}
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return err
+	}
+	def.Required, def.Pattern, def.Enum, def.Min, def.Max = rules.Required, rules.Pattern, rules.Enum, rules.Min, rules.Max
+	def.MinLength, def.MaxLength = rules.MinLength, rules.MaxLength
+	return def.compilePattern()
 }
 
 //FieldType defines a type of Field (String, Date, Number, etc)
 type FieldType interface {
 	GetValue(data string) (interface{}, error)
 }
+
+//RawFieldType is implemented by FieldTypes which read the original bytes of a
+//field (packed decimal, binary etc) rather than its decoded text. The original
+//bytes are available when fields are read from the record by position (for
+//example by a FixedWidthRecordReader).
+type RawFieldType interface {
+	FieldType
+	GetRawValue(data []byte) (interface{}, error)
+}
@@ -1,6 +1,7 @@
 package sfr
 
 import (
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
@@ -25,9 +26,111 @@ type DelimitedRecordReader struct {
 	Delimiter string
 }
 
-//Read splits record based on the configured Coordinates.
+//Read splits record based on the configured Delimiter.
 func (dr DelimitedRecordReader) Read(data []byte) (values []string, err error) {
+	values, _, err = dr.ReadSpans(data)
+	return values, err
+}
+
+//ReadSpans splits record based on the configured Delimiter, returning the
+//span of each value (including any quotes) within the record.
+func (dr DelimitedRecordReader) ReadSpans(data []byte) (values []string, spans []FieldSpan, err error) {
 	csvr := csv.NewReader(strings.NewReader(string(data)))
 	csvr.Comma = []rune(dr.Delimiter)[0]
-	return csvr.Read()
+	values, err = csvr.Read()
+	if err != nil {
+		return values, nil, err
+	}
+	//FieldPos reports columns relative to the line a field starts on, so
+	//find the offset of each line within a multi-line record.
+	lineStarts := []int{0}
+	for i, b := range data {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	spans = make([]FieldSpan, len(values))
+	for i := range values {
+		line, col := csvr.FieldPos(i)
+		spans[i].Start = lineStarts[line-1] + col - 1
+		if i > 0 {
+			spans[i-1].End = spans[i].Start - len(dr.Delimiter)
+		}
+	}
+	if len(spans) > 0 {
+		spans[len(spans)-1].End = len(data)
+	}
+	for i, span := range spans {
+		//encoding/csv replaces "\r\n" in quoted values with "\n" so read them
+		//from the record to keep the original line endings.
+		if raw := data[span.Start:span.End]; len(raw) > 1 && raw[0] == '"' && bytes.IndexByte(raw, '\r') >= 0 {
+			values[i] = strings.Replace(string(raw[1:len(raw)-1]), `""`, `"`, -1)
+		}
+	}
+	return values, spans, nil
+}
+
+//Write joins values using the configured Delimiter, quoting values where required.
+func (dr DelimitedRecordReader) Write(values []string) (data []byte, err error) {
+	var buf bytes.Buffer
+	csvw := csv.NewWriter(&buf)
+	csvw.Comma = []rune(dr.Delimiter)[0]
+	if err = csvw.Write(values); err != nil {
+		return nil, err
+	}
+	csvw.Flush()
+	if err = csvw.Error(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+//Incomplete returns true if data ends within a quoted value, meaning the
+//value contains a line break & the record continues on the next line
+//(see RFC 4180).
+func (dr DelimitedRecordReader) Incomplete(data []byte) bool {
+	return dr.NewContinuation().Add(data)
+}
+
+//NewContinuation returns a Continuation which carries the quote state of a
+//record from line to line.
+func (dr DelimitedRecordReader) NewContinuation() Continuation {
+	return &delimitedContinuation{delim: []byte(dr.Delimiter), fieldStart: true}
+}
+
+//delimitedContinuation tracks whether a Delimited record ends within a quoted value.
+type delimitedContinuation struct {
+	delim      []byte
+	inQuotes   bool
+	fieldStart bool
+}
+
+//Add scans the next part of the record, returning true if it ends within a
+//quoted value.
+func (dc *delimitedContinuation) Add(data []byte) bool {
+	if len(dc.delim) == 0 {
+		return false
+	}
+	for i := 0; i < len(data); i++ {
+		switch {
+		case dc.inQuotes:
+			if data[i] == '"' {
+				if i+1 < len(data) && data[i+1] == '"' {
+					//An escaped quote.
+					i++
+				} else {
+					dc.inQuotes = false
+				}
+			}
+		case dc.fieldStart && data[i] == '"':
+			dc.inQuotes = true
+			dc.fieldStart = false
+		case bytes.HasPrefix(data[i:], dc.delim):
+			i += len(dc.delim) - 1
+			dc.fieldStart = true
+		default:
+			dc.fieldStart = false
+		}
+	}
+	return dc.inQuotes
 }
@@ -0,0 +1,254 @@
+package sfr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+//Encoding converts records from a character encoding into UTF-8.
+//Encodings are selected by name using the Encoding setting of the Parser
+//(for all records) or of a RecordDefinition (overriding the Parser's setting).
+//Records are decoded before they are matched & read. FixedWidth coordinates
+//refer to the bytes of the original record as each value is decoded after it
+//is sliced from the record.
+type Encoding interface {
+	Decode(data []byte) ([]byte, error)
+}
+
+//StreamEncoding is implemented by Encodings (such as UTF-16) in which record
+//terminators cannot be found without decoding the source. When used as the
+//Parser's Encoding, the whole source is decoded to UTF-8 before it is divided
+//into records, so record offsets & FixedWidth coordinates refer to the decoded
+//UTF-8 data. StreamEncodings cannot be used by individual RecordDefinitions.
+type StreamEncoding interface {
+	Encoding
+	NewReader(r io.Reader) io.Reader
+}
+
+//EncodedRecordReader is implemented by RecordReaders which read values from
+//the original bytes of a record & decode each value themselves.
+type EncodedRecordReader interface {
+	ReadEncoded(data []byte, enc Encoding) (values []string, spans []FieldSpan, err error)
+}
+
+//EncodingRegistry holds the Encodings available by name.
+var EncodingRegistry = map[string]Encoding{
+	"UTF-8":        utf8Encoding{},
+	"ISO-8859-1":   singleByteEncoding{table: latin1Table()},
+	"Latin-1":      singleByteEncoding{table: latin1Table()},
+	"Windows-1252": singleByteEncoding{table: windows1252FullTable()},
+	"CP037":        singleByteEncoding{table: &cp037Table},
+	"CP500":        singleByteEncoding{table: &cp500Table},
+	"UTF-16":       utf16Encoding{detectBOM: true, bigEndian: true},
+	"UTF-16BE":     utf16Encoding{bigEndian: true},
+	"UTF-16LE":     utf16Encoding{},
+}
+
+//GetEncoding returns the Encoding registered with the given name.
+func GetEncoding(name string) (Encoding, error) {
+	enc, ok := EncodingRegistry[name]
+	if !ok {
+		return nil, ConfigurationError(fmt.Errorf("No Encoding named \"%s\" exists in registry", name))
+	}
+	return enc, nil
+}
+
+//decodeRecord decodes data using enc (data is returned unchanged if enc is nil).
+func decodeRecord(enc Encoding, data []byte) ([]byte, error) {
+	if enc == nil {
+		return data, nil
+	}
+	return enc.Decode(data)
+}
+
+//readEncoded reads a record using rr. EncodedRecordReaders read from the
+//original data, other RecordReaders read the decoded text.
+func readEncoded(rr RecordReader, enc Encoding, data []byte, text []byte) (values []string, spans []FieldSpan, err error) {
+	if er, ok := rr.(EncodedRecordReader); ok && enc != nil {
+		return er.ReadEncoded(data, enc)
+	}
+	return readSpans(rr, text)
+}
+
+//rawFieldData returns the original bytes of the i'th field of a record for a
+//RawFieldType. When the field spans cannot be located within the original data
+//(for example a delimited record decoded from another encoding) the bytes of
+//the decoded value are returned.
+func rawFieldData(rr RecordReader, enc Encoding, data []byte, spans []FieldSpan, i int, value string) []byte {
+	if _, ok := rr.(EncodedRecordReader); (ok || enc == nil) && i < len(spans) && spans[i].End <= len(data) {
+		return data[spans[i].Start:spans[i].End]
+	}
+	return []byte(value)
+}
+
+/////////
+//UTF-8
+/////////
+
+//utf8Encoding is the default encoding & leaves data unchanged.
+type utf8Encoding struct{}
+
+//Decode returns data unchanged.
+func (utf8Encoding) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+/////////
+//SINGLE BYTE
+/////////
+
+//singleByteEncoding decodes encodings in which each byte is one character.
+type singleByteEncoding struct {
+	table *[256]rune
+}
+
+//Decode converts each byte to the code point in the table.
+func (sbe singleByteEncoding) Decode(data []byte) ([]byte, error) {
+	decoded := make([]byte, 0, len(data))
+	var buf [utf8.UTFMax]byte
+	for _, b := range data {
+		n := utf8.EncodeRune(buf[:], sbe.table[b])
+		decoded = append(decoded, buf[:n]...)
+	}
+	return decoded, nil
+}
+
+func latin1Table() *[256]rune {
+	var table [256]rune
+	for i := range table {
+		table[i] = rune(i)
+	}
+	return &table
+}
+
+func windows1252FullTable() *[256]rune {
+	table := latin1Table()
+	copy(table[0x80:0xA0], windows1252Table[:])
+	return table
+}
+
+/////////
+//UTF-16
+/////////
+
+//utf16Encoding decodes UTF-16. If detectBOM is set, a leading byte order mark
+//selects the byte order (defaulting to bigEndian).
+type utf16Encoding struct {
+	bigEndian bool
+	detectBOM bool
+}
+
+//Decode converts UTF-16 data to UTF-8.
+func (ue utf16Encoding) Decode(data []byte) ([]byte, error) {
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("UTF-16 data has an odd number of bytes")
+	}
+	bigEndian := ue.bigEndian
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i < len(data); i += 2 {
+		if i == 0 && ue.detectBOM {
+			if data[0] == 0xFE && data[1] == 0xFF {
+				bigEndian = true
+				continue
+			} else if data[0] == 0xFF && data[1] == 0xFE {
+				bigEndian = false
+				continue
+			}
+		}
+		units = append(units, unit16(data[i], data[i+1], bigEndian))
+	}
+	return []byte(string(utf16.Decode(units))), nil
+}
+
+//NewReader returns a reader which decodes r from UTF-16 to UTF-8.
+func (ue utf16Encoding) NewReader(r io.Reader) io.Reader {
+	return &utf16Reader{
+		r:          bufio.NewReader(r),
+		bigEndian:  ue.bigEndian,
+		checkBOM:   ue.detectBOM,
+		pendingOut: make([]byte, 0, utf8.UTFMax),
+	}
+}
+
+func unit16(b0, b1 byte, bigEndian bool) uint16 {
+	if bigEndian {
+		return uint16(b0)<<8 | uint16(b1)
+	}
+	return uint16(b1)<<8 | uint16(b0)
+}
+
+//utf16Reader decodes a UTF-16 stream to UTF-8.
+type utf16Reader struct {
+	r          *bufio.Reader
+	bigEndian  bool
+	checkBOM   bool
+	pendingOut []byte
+	err        error
+}
+
+//readUnit reads a single UTF-16 code unit.
+func (ur *utf16Reader) readUnit() (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(ur.r, b[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("UTF-16 data has an odd number of bytes")
+		}
+		return 0, err
+	}
+	return unit16(b[0], b[1], ur.bigEndian), nil
+}
+
+//Read fills p with decoded UTF-8.
+func (ur *utf16Reader) Read(p []byte) (n int, err error) {
+	if ur.checkBOM {
+		ur.checkBOM = false
+		if bom, err := ur.r.Peek(2); err == nil {
+			if bom[0] == 0xFE && bom[1] == 0xFF {
+				ur.bigEndian = true
+				ur.r.Discard(2)
+			} else if bom[0] == 0xFF && bom[1] == 0xFE {
+				ur.bigEndian = false
+				ur.r.Discard(2)
+			}
+		}
+	}
+	for n < len(p) {
+		if len(ur.pendingOut) > 0 {
+			c := copy(p[n:], ur.pendingOut)
+			n += c
+			ur.pendingOut = ur.pendingOut[c:]
+			continue
+		}
+		if ur.err != nil {
+			break
+		}
+		//Don't block waiting for more data once something has been read.
+		if n > 0 && ur.r.Buffered() < 2 {
+			break
+		}
+		unit, err := ur.readUnit()
+		if err != nil {
+			ur.err = err
+			continue
+		}
+		r := rune(unit)
+		if utf16.IsSurrogate(r) {
+			low, err := ur.readUnit()
+			if err != nil {
+				ur.err = err
+				continue
+			}
+			r = utf16.DecodeRune(r, rune(low))
+		}
+		var buf [utf8.UTFMax]byte
+		size := utf8.EncodeRune(buf[:], r)
+		ur.pendingOut = append(ur.pendingOut[:0], buf[:size]...)
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, ur.err
+}
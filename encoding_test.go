@@ -0,0 +1,174 @@
+package sfr
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+const encodingCfg = `
+{
+	"SplitOnRecordName": "Header",
+	"Encoding": "CP037",
+	"RecordLength": 16,
+	"RecordDefinitions": [
+		{
+			"Name": "Header",
+			"MatchExpression": "^H",
+			"ReaderName": "FixedWidth",
+			"RecordReader": {
+				"Coordinates": [
+					{
+						"Start": 0,
+						"End": 1
+					},
+					{
+						"Start": 1,
+						"End": 11
+					},
+					{
+						"Start": 11,
+						"End": 16
+					}
+				]
+			},
+			"FieldDefinitions": [
+				{
+					"Name": "RecordType",
+					"TypeName": "String"
+				},
+				{
+					"Name": "Name",
+					"TypeName": "String"
+				},
+				{
+					"Name": "Amount",
+					"TypeName": "Number",
+					"FieldType": {
+						"ConvertToDecimalPlaces": 2
+					}
+				}
+			]
+		},
+		{
+			"Name": "Note",
+			"ParentRecordName": "Header",
+			"MatchExpression": "^N",
+			"Encoding": "Windows-1252",
+			"ReaderName": "Delimited",
+			"RecordReader": {
+				"Delimiter": ","
+			},
+			"FieldDefinitions": [
+				{
+					"Name": "RecordType",
+					"TypeName": "String"
+				},
+				{
+					"Name": "Text",
+					"TypeName": "String"
+				}
+			]
+		}
+	]
+}
+`
+
+//encodeCP037 encodes s (which must only contain characters in CP037).
+func encodeCP037(s string) []byte {
+	encoded := make([]byte, 0, len(s))
+	for _, r := range s {
+		for b, cp := range cp037Table {
+			if cp == r {
+				encoded = append(encoded, byte(b))
+				break
+			}
+		}
+	}
+	return encoded
+}
+
+func TestSingleByteEncodings(t *testing.T) {
+	headers := make([]*Record, 0)
+	p, err := NewParser(
+		ioutil.NopCloser(strings.NewReader(encodingCfg)),
+		func(record *Record) error {
+			headers = append(headers, record)
+			return nil
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(encodeCP037("HMüller    12345"), []byte("N,\x93Caf\xe9\x94 ok  ")...)
+	if err = p.Parse(ioutil.NopCloser(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	name, _ := headers[0].GetField("Name")
+	amount, _ := headers[0].GetField("Amount")
+	if name.Value != "Müller    " || name.Start != 1 || name.End != 11 || amount.Value != 123.45 {
+		t.Errorf("Unexpected header fields %v & %v", name, amount)
+	}
+	text, _ := headers[0].Children[0].GetField("Text")
+	if text.Value != "“Café” ok  " {
+		t.Errorf("Unexpected note %q", text.Value)
+	}
+}
+
+func TestUTF16Encoding(t *testing.T) {
+	units := utf16.Encode([]rune("\uFEFF010~INV€1~12345~17-JUL-2019\r\n030~0001~Zürich\r\n033ACCTNUM001\r\n"))
+	data := make([]byte, 0, len(units)*2)
+	for _, unit := range units {
+		data = append(data, byte(unit), byte(unit>>8))
+	}
+	invoices := parseInvoices(t, string(data), func(p *Parser) { p.Encoding = "UTF-16" })
+	invnum, _ := invoices[0].GetField("InvoiceNumber")
+	desc, _ := invoices[0].Children[0].GetField("Description")
+	if invnum.Value != "INV€1" || desc.Value != "Zürich" || len(invoices[0].Children[0].Children) != 1 {
+		t.Errorf("Unexpected invoice %v", invoices[0])
+	}
+}
+
+//Delimited Records are split after decoding, so their Field offsets are into
+//the decoded line rather than Raw.
+func TestDelimitedEncodedFieldOffsets(t *testing.T) {
+	cfg := `
+{
+	"SplitOnRecordName": "Line",
+	"Encoding": "Latin-1",
+	"RecordDefinitions": [
+		{
+			"Name": "Line",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "Name", "TypeName": "String"},
+				{"Name": "Code", "TypeName": "String"}
+			]
+		}
+	]
+}
+`
+	var line *Record
+	p, err := NewParser(
+		ioutil.NopCloser(strings.NewReader(cfg)),
+		RecordProcessor(func(record *Record) error {
+			line = record
+			return nil
+		}),
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Parse(ioutil.NopCloser(bytes.NewReader([]byte("\xe9t\xe9,XY\n")))); err != nil {
+		t.Fatal(err)
+	}
+	code := line.Fields[1]
+	if len(line.Raw) != 6 || code.Start != 6 || code.End != 8 {
+		t.Errorf("Expected Code to span 6-8 of the decoded line (Raw is %d bytes), got %d-%d", len(line.Raw), code.Start, code.End)
+	}
+}
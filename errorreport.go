@@ -0,0 +1,213 @@
+package sfr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+//ErrorCollector is an ErrorHandler (see Handle) which records every error &
+//lets parsing continue, so that a single pass over a source lists every
+//problem. Errors are grouped by record name, field name, error type & (for
+//FieldParseErrors) FieldRule, keeping up to MaxSamples samples per group.
+type ErrorCollector struct {
+	MaxSamples int
+	mu         sync.Mutex
+	total      int
+	groups     map[errorGroupKey]*ErrorGroup
+	order      []*ErrorGroup
+}
+
+//ErrorReport summarises the errors collected by an ErrorCollector.
+type ErrorReport struct {
+	Total  int
+	Groups []ErrorGroup
+}
+
+//ErrorGroup summarises the errors of one type on one record (& field). Type is
+//the name of the error type (such as "FieldParseError"), or "Error" for errors
+//of other types. FirstLineNum & LastLineNum are 0 if none of the errors had a line number.
+type ErrorGroup struct {
+	RecordName   string `json:",omitempty"`
+	FieldName    string `json:",omitempty"`
+	Type         string
+	Rule         FieldRule `json:",omitempty"`
+	Count        int
+	FirstLineNum int
+	LastLineNum  int
+	Samples      []ErrorSample
+}
+
+//ErrorSample is one of the errors in an ErrorGroup.
+type ErrorSample struct {
+	LineNum int    `json:",omitempty"`
+	Snippet string `json:",omitempty"`
+	Message string
+}
+
+type errorGroupKey struct {
+	recordName string
+	fieldName  string
+	errType    string
+	rule       FieldRule
+}
+
+//NewErrorCollector returns an ErrorCollector keeping up to maxSamples samples
+//of each group of errors.
+func NewErrorCollector(maxSamples int) *ErrorCollector {
+	return &ErrorCollector{
+		MaxSamples: maxSamples,
+		groups:     make(map[errorGroupKey]*ErrorGroup),
+	}
+}
+
+//Handle records err & returns nil so that parsing continues. Use it as the
+//Parser's ErrorHandler.
+func (ec *ErrorCollector) Handle(err error) error {
+	if err == nil {
+		return nil
+	}
+	key, lineNum, snip := errorDetails(err)
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	if ec.groups == nil {
+		ec.groups = make(map[errorGroupKey]*ErrorGroup)
+	}
+	ec.total++
+	group, ok := ec.groups[key]
+	if !ok {
+		group = &ErrorGroup{
+			RecordName: key.recordName,
+			FieldName:  key.fieldName,
+			Type:       key.errType,
+			Rule:       key.rule,
+			Samples:    make([]ErrorSample, 0),
+		}
+		ec.groups[key] = group
+		ec.order = append(ec.order, group)
+	}
+	group.Count++
+	if lineNum > 0 {
+		if group.FirstLineNum == 0 || lineNum < group.FirstLineNum {
+			group.FirstLineNum = lineNum
+		}
+		if lineNum > group.LastLineNum {
+			group.LastLineNum = lineNum
+		}
+	}
+	if len(group.Samples) < ec.MaxSamples {
+		group.Samples = append(group.Samples, ErrorSample{LineNum: lineNum, Snippet: snip, Message: err.Error()})
+	}
+	return nil
+}
+
+//errorDetails returns the group, line number & snippet of err. ProcessorErrors
+//are checked first as they may wrap the other types.
+func errorDetails(err error) (key errorGroupKey, lineNum int, snip string) {
+	var (
+		rpe RecordParseError
+		fpe FieldParseError
+		cte ControlTotalError
+		rse RecordSequenceError
+		ule UnmatchedLineError
+		pe  ProcessorError
+	)
+	switch {
+	case errors.As(err, &pe):
+		return errorGroupKey{recordName: pe.RecordName, errType: "ProcessorError"}, pe.LineNum, ""
+	case errors.As(err, &fpe):
+		return errorGroupKey{fpe.RecordName, fpe.FieldName, "FieldParseError", fpe.Rule}, fpe.LineNum, fpe.Snippet
+	case errors.As(err, &rpe):
+		return errorGroupKey{recordName: rpe.RecordName, errType: "RecordParseError"}, rpe.LineNum, rpe.Snippet
+	case errors.As(err, &cte):
+		return errorGroupKey{recordName: cte.RecordName, fieldName: cte.FieldName, errType: "ControlTotalError"}, cte.LineNum, ""
+	case errors.As(err, &rse):
+		return errorGroupKey{recordName: rse.RecordName, errType: "RecordSequenceError"}, rse.LineNum, rse.Snippet
+	case errors.As(err, &ule):
+		return errorGroupKey{errType: "UnmatchedLineError"}, ule.LineNum, ule.Snippet
+	}
+	return errorGroupKey{errType: "Error"}, 0, ""
+}
+
+//Total returns the number of errors collected.
+func (ec *ErrorCollector) Total() int {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	return ec.total
+}
+
+//Report returns a copy of the errors collected so far, grouped in the order
+//each group was first seen.
+func (ec *ErrorCollector) Report() ErrorReport {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	report := ErrorReport{Total: ec.total, Groups: make([]ErrorGroup, len(ec.order))}
+	for i, group := range ec.order {
+		report.Groups[i] = *group
+		report.Groups[i].Samples = append([]ErrorSample(nil), group.Samples...)
+	}
+	return report
+}
+
+//WriteJSON writes the ErrorReport to w as indented JSON.
+func (ec *ErrorCollector) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ec.Report())
+}
+
+//WriteText writes the ErrorReport to w as plain text, for example:
+//
+//	3 errors in 2 groups
+//	FieldParseError (Enum) on Record "POLine", Field "UOM": 2 errors on lines 3-7
+//	  line 3: Record "POLine", Field "UOM" on line 3, column 4: Value is not one of [EA KG] ("BOX")
+//	  ...
+//	Error: 1 error
+//	  Some other failure
+func (ec *ErrorCollector) WriteText(w io.Writer) error {
+	report := ec.Report()
+	if _, err := fmt.Fprintf(w, "%s in %s\n", plural(report.Total, "error"), plural(len(report.Groups), "group")); err != nil {
+		return err
+	}
+	for _, group := range report.Groups {
+		desc := group.Type
+		if group.Rule != "" {
+			desc += fmt.Sprintf(" (%s)", group.Rule)
+		}
+		if group.RecordName != "" {
+			desc += fmt.Sprintf(" on Record \"%s\"", group.RecordName)
+			if group.FieldName != "" {
+				desc += fmt.Sprintf(", Field \"%s\"", group.FieldName)
+			}
+		}
+		lines := ""
+		if group.FirstLineNum > 0 && group.FirstLineNum == group.LastLineNum {
+			lines = fmt.Sprintf(" on line %d", group.FirstLineNum)
+		} else if group.FirstLineNum > 0 {
+			lines = fmt.Sprintf(" on lines %d-%d", group.FirstLineNum, group.LastLineNum)
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s%s\n", desc, plural(group.Count, "error"), lines); err != nil {
+			return err
+		}
+		for _, sample := range group.Samples {
+			prefix := ""
+			if sample.LineNum > 0 {
+				prefix = fmt.Sprintf("line %d: ", sample.LineNum)
+			}
+			if _, err := fmt.Fprintf(w, "  %s%s\n", prefix, sample.Message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//plural returns n followed by noun, adding an "s" unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,67 @@
+package sfr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestErrorCollector(t *testing.T) {
+	data := strings.Join([]string{
+		"PO1,BOX,5",
+		"PO2,EA,0",
+		"PO3,CASE,5",
+		"PO4,BAG,5",
+	}, "\n")
+	ec := NewErrorCollector(2)
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(fieldRulesCfg)), nil, ec.Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	ec.Handle(nil)
+	ec.Handle(errors.New("other"))
+	report := ec.Report()
+	if report.Total != 5 || len(report.Groups) != 3 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	enum := report.Groups[0]
+	if enum.Type != "FieldParseError" || enum.Rule != EnumRule || enum.RecordName != "POLine" || enum.FieldName != "UOM" ||
+		enum.Count != 3 || enum.FirstLineNum != 1 || enum.LastLineNum != 4 || len(enum.Samples) != 2 || enum.Samples[1].Snippet != "CASE" {
+		t.Errorf("Unexpected Enum group %+v", enum)
+	}
+	if report.Groups[1].Rule != MinRule || report.Groups[2].Type != "Error" {
+		t.Errorf("Unexpected groups %+v", report.Groups[1:])
+	}
+
+	var buf bytes.Buffer
+	if err = ec.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded ErrorReport
+	if err = json.Unmarshal(buf.Bytes(), &decoded); err != nil || decoded.Total != 5 || decoded.Groups[0].Count != 3 {
+		t.Errorf("Unexpected JSON report %s (%v)", buf.String(), err)
+	}
+	buf.Reset()
+	if err = ec.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "5 errors in 3 groups\nFieldParseError (Enum) on Record \"POLine\", Field \"UOM\": 3 errors on lines 1-4\n  line 1: ") ||
+		!strings.HasSuffix(buf.String(), "\nError: 1 error\n  other\n") {
+		t.Errorf("Unexpected text report %s", buf.String())
+	}
+}
+
+func TestErrorCollectorProcessorError(t *testing.T) {
+	ec := NewErrorCollector(1)
+	ec.Handle(ProcessorError{RecordName: "POHeader", LineNum: 2, Err: FieldParseError{RecordName: "POLine", FieldName: "UOM", LineNum: 3}})
+	report := ec.Report()
+	if len(report.Groups) != 1 || report.Groups[0].Type != "ProcessorError" || report.Groups[0].RecordName != "POHeader" || report.Groups[0].FirstLineNum != 2 {
+		t.Errorf("Expected a ProcessorError group, got %+v", report.Groups)
+	}
+}
@@ -1,27 +1,196 @@
 package sfr
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 //ConfigurationError is a generic error to denote that the configuration is invalid.
 type ConfigurationError error
 
-//RecordParseError denotes an error processing a Record from a RecordDefinition
+//ConfigurationErrors lists every problem found when validating a configuration.
+type ConfigurationErrors []error
+
+func (ce ConfigurationErrors) Error() string {
+	msgs := make([]string, len(ce))
+	for i, err := range ce {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d configuration errors: %s", len(ce), strings.Join(msgs, "; "))
+}
+
+//Unwrap returns the individual errors.
+func (ce ConfigurationErrors) Unwrap() []error {
+	return ce
+}
+
+//RecordParseError denotes an error processing a Record from a RecordDefinition.
+//LineNum & Snippet (the start of the raw line) are set for errors found while
+//parsing (LineNum is 0 otherwise).
 type RecordParseError struct {
 	RecordName string
 	Text       string
+	LineNum    int
+	Snippet    string
 }
 
 func (re RecordParseError) Error() string {
-	return fmt.Sprintf("Record \"%s\": %s", re.RecordName, re.Text)
+	if re.LineNum == 0 {
+		return fmt.Sprintf("Record \"%s\": %s", re.RecordName, re.Text)
+	}
+	return fmt.Sprintf("Record \"%s\" on line %d: %s (%q)", re.RecordName, re.LineNum, re.Text, re.Snippet)
 }
 
-//FieldParseError denotes an error processing a Field from a FieldDefinition
+//FieldParseError denotes an error processing a Field from a FieldDefinition.
+//LineNum, Column (the 0-based byte offset of the Field, as in Field.Start) &
+//Snippet (the raw Field value) are set for errors found while parsing (LineNum
+//is 0 otherwise). Rule is set if the value broke a FieldDefinition validation rule.
 type FieldParseError struct {
 	RecordName string
 	FieldName  string
 	Text       string
+	LineNum    int
+	Column     int
+	Snippet    string
+	Rule       FieldRule
 }
 
 func (fe FieldParseError) Error() string {
-	return fmt.Sprintf("Record \"%s\", Field \"%s\": %s", fe.RecordName, fe.FieldName, fe.Text)
+	if fe.LineNum == 0 {
+		return fmt.Sprintf("Record \"%s\", Field \"%s\": %s", fe.RecordName, fe.FieldName, fe.Text)
+	}
+	return fmt.Sprintf("Record \"%s\", Field \"%s\" on line %d, column %d: %s (%q)", fe.RecordName, fe.FieldName, fe.LineNum, fe.Column, fe.Text, fe.Snippet)
+}
+
+//maxSnippetLength limits the length of the raw line included in errors.
+const maxSnippetLength = 80
+
+//snippet returns the start of line for inclusion in errors.
+func snippet(line []byte) string {
+	if len(line) > maxSnippetLength {
+		return string(line[:maxSnippetLength]) + "..."
+	}
+	return string(line)
+}
+
+//ContextError denotes that parsing stopped because its context was done.
+//LineNum is the last line read before parsing stopped.
+type ContextError struct {
+	LineNum int
+	Err     error
+}
+
+func (ce ContextError) Error() string {
+	return fmt.Sprintf("Parsing stopped on line %d: %s", ce.LineNum, ce.Err)
+}
+
+//Unwrap returns the context's error so that errors.Is(err, context.Canceled) works.
+func (ce ContextError) Unwrap() error {
+	return ce.Err
+}
+
+//ProcessorError denotes an error returned by a RecordProcessor run by one of
+//the Parser's Workers (or by AncestorComplete). LineNum is the line the Record
+//was read from.
+type ProcessorError struct {
+	RecordName string
+	LineNum    int
+	Err        error
+}
+
+func (pe ProcessorError) Error() string {
+	return fmt.Sprintf("Record \"%s\" on line %d: %s", pe.RecordName, pe.LineNum, pe.Err)
+}
+
+//Unwrap returns the error returned by the RecordProcessor.
+func (pe ProcessorError) Unwrap() error {
+	return pe.Err
+}
+
+//SourceError denotes a failure reading records from the source, such as a
+//record longer than the Parser's MaxRecordSize. LineNum is the line which
+//could not be read.
+type SourceError struct {
+	LineNum int
+	Err     error
+}
+
+func (se SourceError) Error() string {
+	return fmt.Sprintf("Error reading line %d: %s", se.LineNum, se.Err)
+}
+
+//Unwrap returns the error returned by the source.
+func (se SourceError) Unwrap() error {
+	return se.Err
+}
+
+//JoinBufferError denotes a failure buffering Records for a Buffered join.
+//Either the Records held in memory exceeded the Parser's JoinBufferSize (&
+//JoinSpillToDisk is not set) or spilling them to disk failed (Err is set).
+type JoinBufferError struct {
+	LineNum int
+	Size    int64
+	Err     error
+}
+
+func (jbe JoinBufferError) Error() string {
+	if jbe.Err != nil {
+		return fmt.Sprintf("Error spilling buffered records at line %d: %s", jbe.LineNum, jbe.Err)
+	}
+	return fmt.Sprintf("Buffered records exceed JoinBufferSize of %d bytes at line %d", jbe.Size, jbe.LineNum)
+}
+
+//Unwrap returns the error from the spill file.
+func (jbe JoinBufferError) Unwrap() error {
+	return jbe.Err
+}
+
+//UnmatchedLineError denotes a line which matches no RecordDefinition (see
+//UnmatchedLinePolicy). Offset is the byte offset of the line in the source.
+type UnmatchedLineError struct {
+	LineNum int
+	Offset  int64
+	Snippet string
+}
+
+func (ule UnmatchedLineError) Error() string {
+	return fmt.Sprintf("Line %d matches no RecordDefinition (%q)", ule.LineNum, ule.Snippet)
+}
+
+//ControlTotalError denotes a control Field (Expected) which doesn't match the
+//total accumulated from earlier Records (Actual) by its ControlTotal Rule.
+type ControlTotalError struct {
+	RecordName string
+	FieldName  string
+	LineNum    int
+	Rule       ControlTotal
+	Expected   float64
+	Actual     float64
+}
+
+func (cte ControlTotalError) Error() string {
+	total := fmt.Sprintf("count of %s", cte.Rule.RecordName)
+	if cte.Rule.SumFieldName != "" {
+		total = fmt.Sprintf("sum of %s.%s", cte.Rule.RecordName, cte.Rule.SumFieldName)
+	}
+	return fmt.Sprintf("Record \"%s\" on line %d: control total %s is %v but the %s is %v",
+		cte.RecordName, cte.LineNum, cte.FieldName, cte.Expected, total, cte.Actual)
+}
+
+//RecordSequenceError denotes the first Record which doesn't follow the Parser's
+//RecordSequence. Expected lists the record names which were allowed instead.
+//RecordName is empty if the source ended early (LineNum is then the line after
+//the last line).
+type RecordSequenceError struct {
+	RecordName string
+	LineNum    int
+	Expected   []string
+	Snippet    string
+}
+
+func (rse RecordSequenceError) Error() string {
+	if rse.RecordName == "" {
+		return fmt.Sprintf("Unexpected end of source at line %d, expected %s", rse.LineNum, strings.Join(rse.Expected, " or "))
+	}
+	return fmt.Sprintf("Record \"%s\" on line %d is out of sequence, expected %s (%q)", rse.RecordName, rse.LineNum, strings.Join(rse.Expected, " or "), rse.Snippet)
 }
@@ -0,0 +1,159 @@
+package sfr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+//FieldRule identifies the FieldDefinition validation rule which failed in the
+//Rule of a FieldParseError.
+type FieldRule string
+
+//The FieldDefinition validation rules.
+const (
+	RequiredRule  FieldRule = "Required"
+	PatternRule   FieldRule = "Pattern"
+	MinLengthRule FieldRule = "MinLength"
+	MaxLengthRule FieldRule = "MaxLength"
+	EnumRule      FieldRule = "Enum"
+	MinRule       FieldRule = "Min"
+	MaxRule       FieldRule = "Max"
+)
+
+//fieldRuleFailure describes a value which breaks a validation rule.
+type fieldRuleFailure struct {
+	rule FieldRule
+	text string
+}
+
+//compilePattern caches the compiled Pattern on the FieldDefinition for
+//compilePatterns to reuse.
+func (def *FieldDefinition) compilePattern() error {
+	re, err := def.compiledPattern()
+	if err != nil {
+		return err
+	}
+	def.patternRegexp = re
+	return nil
+}
+
+//compiledPattern returns the compiled Pattern without storing it.
+func (def *FieldDefinition) compiledPattern() (*regexp.Regexp, error) {
+	re, err := compileRegexp(def.Pattern, def.patternRegexp)
+	if err != nil {
+		return nil, ConfigurationError(fmt.Errorf("Invalid Pattern in FieldDefinition \"%s\": %s", def.Name, err))
+	}
+	return re, nil
+}
+
+//compilePatterns returns the compiled Patterns of the FieldDefinitions of each
+//RecordDefinition which has any, so that they are compiled once per parse.
+func compilePatterns(recDefs []*RecordDefinition) (map[*RecordDefinition][]*regexp.Regexp, error) {
+	patterns := make(map[*RecordDefinition][]*regexp.Regexp)
+	for _, recDef := range recDefs {
+		for i := range recDef.FieldDefinitions {
+			re, err := recDef.FieldDefinitions[i].compiledPattern()
+			if err != nil {
+				return nil, err
+			}
+			if re == nil {
+				continue
+			}
+			if patterns[recDef] == nil {
+				patterns[recDef] = make([]*regexp.Regexp, len(recDef.FieldDefinitions))
+			}
+			patterns[recDef][i] = re
+		}
+	}
+	return patterns, nil
+}
+
+//producesNumbers returns true if ft produces float64 values, so that Min &
+//Max can be checked.
+func producesNumbers(ft FieldType) bool {
+	switch ft.(type) {
+	case NumberFieldType, PackedDecimalFieldType, ZonedDecimalFieldType, BinaryFieldType:
+		return true
+	}
+	return false
+}
+
+//hasRules returns true if any validation rules are set.
+func (def *FieldDefinition) hasRules() bool {
+	return def.Required || def.Pattern != "" || def.MinLength > 0 || def.MaxLength > 0 ||
+		len(def.Enum) > 0 || def.Min != nil || def.Max != nil
+}
+
+//checkRules returns the validation rules broken by a Field read from data with
+//the given value. pattern is the compiled Pattern.
+func (def *FieldDefinition) checkRules(data string, value interface{}, pattern *regexp.Regexp) []fieldRuleFailure {
+	if !def.hasRules() {
+		return nil
+	}
+	failures := make([]fieldRuleFailure, 0)
+	text := strings.TrimSpace(data)
+	if text == "" {
+		if def.Required {
+			failures = append(failures, fieldRuleFailure{RequiredRule, "Required value is blank"})
+		}
+		return failures
+	}
+	if pattern != nil {
+		if !pattern.MatchString(text) {
+			failures = append(failures, fieldRuleFailure{PatternRule, fmt.Sprintf("Value does not match Pattern %q", def.Pattern)})
+		}
+	}
+	length := utf8.RuneCountInString(text)
+	if length < def.MinLength {
+		failures = append(failures, fieldRuleFailure{MinLengthRule, fmt.Sprintf("Length %d is less than MinLength %d", length, def.MinLength)})
+	}
+	if def.MaxLength > 0 && length > def.MaxLength {
+		failures = append(failures, fieldRuleFailure{MaxLengthRule, fmt.Sprintf("Length %d is more than MaxLength %d", length, def.MaxLength)})
+	}
+	if len(def.Enum) > 0 {
+		found := false
+		for _, e := range def.Enum {
+			if text == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			failures = append(failures, fieldRuleFailure{EnumRule, fmt.Sprintf("Value is not one of %v", def.Enum)})
+		}
+	}
+	if num, ok := value.(float64); ok {
+		if def.Min != nil && num < *def.Min {
+			failures = append(failures, fieldRuleFailure{MinRule, fmt.Sprintf("Value %v is less than Min %v", num, *def.Min)})
+		}
+		if def.Max != nil && num > *def.Max {
+			failures = append(failures, fieldRuleFailure{MaxRule, fmt.Sprintf("Value %v is more than Max %v", num, *def.Max)})
+		}
+	}
+	return failures
+}
+
+//validateRules checks the validation rules of fldDef are consistent, compiling
+//its Pattern.
+func validateRules(fldDef *FieldDefinition) []error {
+	errs := make([]error, 0)
+	if fldDef.MinLength < 0 || fldDef.MaxLength < 0 || (fldDef.MaxLength > 0 && fldDef.MinLength > fldDef.MaxLength) {
+		errs = append(errs, fmt.Errorf("MinLength %d & MaxLength %d must not be negative & MinLength must not exceed MaxLength", fldDef.MinLength, fldDef.MaxLength))
+	}
+	if fldDef.Min != nil && fldDef.Max != nil && *fldDef.Min > *fldDef.Max {
+		errs = append(errs, fmt.Errorf("Min %v must not exceed Max %v", *fldDef.Min, *fldDef.Max))
+	}
+	if (fldDef.Min != nil || fldDef.Max != nil) && fldDef.FieldType != nil && !producesNumbers(fldDef.FieldType) {
+		errs = append(errs, fmt.Errorf("Min & Max require a FieldType producing Numbers, not \"%s\"", fldDef.TypeName))
+	}
+	if fldDef.Pattern != "" {
+		if re, err := compileRegexp(fldDef.Pattern, fldDef.patternRegexp); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid Pattern: %s", err))
+		} else {
+			fldDef.patternRegexp = re
+		}
+	}
+	return errs
+}
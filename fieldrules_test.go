@@ -0,0 +1,74 @@
+package sfr
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const fieldRulesCfg = `
+{
+	"SplitOnRecordName": "POLine",
+	"RecordDefinitions": [
+		{
+			"Name": "POLine",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "PONumber", "TypeName": "String", "Required": true, "Pattern": "^PO[0-9]+$", "MaxLength": 5},
+				{"Name": "UOM", "TypeName": "String", "Enum": ["EA", "KG"]},
+				{"Name": "Qty", "TypeName": "Number", "Min": 1, "Max": 100}
+			]
+		}
+	]
+}
+`
+
+func TestFieldRules(t *testing.T) {
+	data := strings.Join([]string{
+		"PO1,EA,5",
+		" ,KG,1",
+		"PO1234,BOX,0",
+		"XX,,101",
+	}, "\n")
+	rules := make([]FieldRule, 0)
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(fieldRulesCfg)), nil,
+		func(err error) error {
+			var fpe FieldParseError
+			if !errors.As(err, &fpe) {
+				return err
+			}
+			rules = append(rules, fpe.Rule)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	expected := []FieldRule{RequiredRule, MaxLengthRule, EnumRule, MinRule, PatternRule, MaxRule}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Expected rules %v, got %v", expected, rules)
+	}
+}
+
+func TestValidateFieldRules(t *testing.T) {
+	cfg := strings.Replace(fieldRulesCfg, `"Min": 1`, `"Min": 1000`, 1)
+	_, err := NewParser(ioutil.NopCloser(strings.NewReader(cfg)), nil, nil)
+	var ces ConfigurationErrors
+	if !errors.As(err, &ces) || len(ces) != 1 {
+		t.Errorf("Expected 1 ConfigurationError, got %v", err)
+	}
+}
+
+func TestValidateFieldRulesMinOnString(t *testing.T) {
+	cfg := strings.Replace(fieldRulesCfg, `"Enum": ["EA", "KG"]`, `"Enum": ["EA", "KG"], "Min": 1`, 1)
+	_, err := NewParser(ioutil.NopCloser(strings.NewReader(cfg)), nil, nil)
+	var ces ConfigurationErrors
+	if !errors.As(err, &ces) || len(ces) != 1 || !strings.Contains(ces[0].Error(), "UOM") {
+		t.Errorf("Expected a ConfigurationError for Min on UOM, got %v", err)
+	}
+}
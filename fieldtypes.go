@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -25,6 +26,15 @@ func (sft StringFieldType) GetValue(data string) (interface{}, error) {
 	return data, nil
 }
 
+//FormatValue returns the string value of a String Field.
+func (sft StringFieldType) FormatValue(value interface{}) (string, error) {
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("Expected a string value, got %T", value)
+	}
+	return str, nil
+}
+
 /////////
 //NUMBER
 /////////
@@ -44,15 +54,29 @@ type NumberFieldType struct {
 	ConvertToDecimalPlaces int
 }
 
-//GetValue returns a field containing a float64 value.
+//GetValue returns a field containing a float64 value. Surrounding spaces (such
+//as the padding of fixed width fields) are ignored.
 func (nft NumberFieldType) GetValue(data string) (interface{}, error) {
-	val, err := strconv.ParseFloat(data, 64)
+	val, err := strconv.ParseFloat(strings.TrimSpace(data), 64)
 	if err != nil {
 		return nil, err
 	}
 	return val / math.Pow(10, float64(nft.ConvertToDecimalPlaces)), nil
 }
 
+//FormatValue returns the text of a Number Field, shifting the decimal point back
+//by ConvertToDecimalPlaces.
+func (nft NumberFieldType) FormatValue(value interface{}) (string, error) {
+	val, ok := value.(float64)
+	if !ok {
+		return "", fmt.Errorf("Expected a float64 value, got %T", value)
+	}
+	if nft.ConvertToDecimalPlaces == 0 {
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	}
+	return strconv.FormatFloat(math.Round(val*math.Pow(10, float64(nft.ConvertToDecimalPlaces))), 'f', 0, 64), nil
+}
+
 /////////
 //DATE
 /////////
@@ -81,3 +105,320 @@ type DateFieldType struct {
 func (dft DateFieldType) GetValue(data string) (interface{}, error) {
 	return time.Parse(dft.Format, data)
 }
+
+//FormatValue returns the text of a Date Field using the configured Format.
+func (dft DateFieldType) FormatValue(value interface{}) (string, error) {
+	t, ok := value.(time.Time)
+	if !ok {
+		return "", fmt.Errorf("Expected a time.Time value, got %T", value)
+	}
+	return t.Format(dft.Format), nil
+}
+
+/////////
+//PACKED DECIMAL
+/////////
+func init() {
+	FieldTypeRegistry["PackedDecimal"] = FieldTypeUnmarshalFunc(func(data []byte) (FieldType, error) {
+		pdft := PackedDecimalFieldType{}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &pdft); err != nil {
+				return nil, err
+			}
+		}
+		return pdft, nil
+	})
+}
+
+//PackedDecimalFieldType is a RawFieldType which produces Fields containing
+//float64 values from packed decimal (COMP-3) data: two digits per byte with the
+//sign in the last nibble (C, A, E or F positive, D or B negative).
+type PackedDecimalFieldType struct {
+	ConvertToDecimalPlaces int
+}
+
+//GetValue returns a field containing a float64 value read from the bytes of data.
+func (pdft PackedDecimalFieldType) GetValue(data string) (interface{}, error) {
+	return pdft.GetRawValue([]byte(data))
+}
+
+//GetRawValue returns a field containing a float64 value.
+func (pdft PackedDecimalFieldType) GetRawValue(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("No packed decimal data")
+	}
+	var val float64
+	for i, b := range data {
+		high, low := b>>4, b&0x0F
+		if high > 9 {
+			return nil, fmt.Errorf("Invalid packed decimal digit %X at byte %d", high, i)
+		}
+		val = val*10 + float64(high)
+		if i == len(data)-1 {
+			switch low {
+			case 0x0C, 0x0A, 0x0E, 0x0F:
+			case 0x0D, 0x0B:
+				val = -val
+			default:
+				return nil, fmt.Errorf("Invalid packed decimal sign %X", low)
+			}
+			continue
+		}
+		if low > 9 {
+			return nil, fmt.Errorf("Invalid packed decimal digit %X at byte %d", low, i)
+		}
+		val = val*10 + float64(low)
+	}
+	return val / math.Pow(10, float64(pdft.ConvertToDecimalPlaces)), nil
+}
+
+//FormatValue returns the packed decimal bytes of a PackedDecimal Field in as
+//few bytes as possible.
+func (pdft PackedDecimalFieldType) FormatValue(value interface{}) (string, error) {
+	data, err := pdft.FormatRawValue(value, 0)
+	return string(data), err
+}
+
+//FormatRawValue returns the packed decimal bytes of a PackedDecimal Field,
+//padded with leading zeros to width bytes (if width is positive). The sign is
+//written as C (positive) or D (negative).
+func (pdft PackedDecimalFieldType) FormatRawValue(value interface{}, width int) ([]byte, error) {
+	digits, negative, err := scaledDigits(value, pdft.ConvertToDecimalPlaces)
+	if err != nil {
+		return nil, err
+	}
+	//Two digits per byte, with the sign in the last nibble.
+	size := (len(digits) + 2) / 2
+	if width > 0 {
+		if size > width {
+			return nil, fmt.Errorf("Value %v needs %d bytes, wider than field width %d", value, size, width)
+		}
+		size = width
+	}
+	digits = strings.Repeat("0", 2*size-1-len(digits)) + digits
+	sign := byte(0x0C)
+	if negative {
+		sign = 0x0D
+	}
+	data := make([]byte, size)
+	for i := range data {
+		low := sign
+		if 2*i+1 < len(digits) {
+			low = digits[2*i+1] - '0'
+		}
+		data[i] = (digits[2*i]-'0')<<4 | low
+	}
+	return data, nil
+}
+
+//scaledDigits returns the decimal digits of value (a float64) with the decimal
+//point shifted forward by decimalPlaces, & whether it is negative.
+func scaledDigits(value interface{}, decimalPlaces int) (digits string, negative bool, err error) {
+	val, ok := value.(float64)
+	if !ok {
+		return "", false, fmt.Errorf("Expected a float64 value, got %T", value)
+	}
+	val = math.Round(val * math.Pow(10, float64(decimalPlaces)))
+	if math.IsInf(val, 0) || math.IsNaN(val) {
+		return "", false, fmt.Errorf("Cannot format %v as a decimal", value)
+	}
+	return strconv.FormatFloat(math.Abs(val), 'f', 0, 64), val < 0, nil
+}
+
+/////////
+//ZONED DECIMAL
+/////////
+func init() {
+	FieldTypeRegistry["ZonedDecimal"] = FieldTypeUnmarshalFunc(func(data []byte) (FieldType, error) {
+		zdft := ZonedDecimalFieldType{}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &zdft); err != nil {
+				return nil, err
+			}
+		}
+		return zdft, nil
+	})
+}
+
+//ZonedDecimalFieldType is a RawFieldType which produces Fields containing
+//float64 values from signed zoned decimal data: one digit per byte with the
+//sign overpunched on the last digit. Both EBCDIC (F unsigned, C positive, D
+//negative zones) & ASCII ("{", "A"-"I" positive, "}", "J"-"R" or "p"-"y"
+//negative) conventions are accepted.
+type ZonedDecimalFieldType struct {
+	ConvertToDecimalPlaces int
+}
+
+//GetValue returns a field containing a float64 value read from the bytes of data.
+func (zdft ZonedDecimalFieldType) GetValue(data string) (interface{}, error) {
+	return zdft.GetRawValue([]byte(data))
+}
+
+//GetRawValue returns a field containing a float64 value.
+func (zdft ZonedDecimalFieldType) GetRawValue(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("No zoned decimal data")
+	}
+	var val float64
+	last := len(data) - 1
+	for i, b := range data[:last] {
+		if (b < '0' || b > '9') && (b < 0xF0 || b > 0xF9) {
+			return nil, fmt.Errorf("Invalid zoned decimal digit %X at byte %d", b, i)
+		}
+		val = val*10 + float64(b&0x0F)
+	}
+	digit, negative, ok := zonedSignDigit(data[last])
+	if !ok {
+		return nil, fmt.Errorf("Invalid zoned decimal sign %X", data[last])
+	}
+	val = val*10 + float64(digit)
+	if negative {
+		val = -val
+	}
+	return val / math.Pow(10, float64(zdft.ConvertToDecimalPlaces)), nil
+}
+
+//FormatValue returns the zoned decimal text of a ZonedDecimal Field.
+func (zdft ZonedDecimalFieldType) FormatValue(value interface{}) (string, error) {
+	data, err := zdft.FormatRawValue(value, 0)
+	return string(data), err
+}
+
+//FormatRawValue returns the zoned decimal bytes of a ZonedDecimal Field,
+//padded with leading zeros to width bytes (if width is positive). Digits are
+//written in ASCII with negative values overpunched ("}", "J"-"R") on the last
+//digit.
+func (zdft ZonedDecimalFieldType) FormatRawValue(value interface{}, width int) ([]byte, error) {
+	digits, negative, err := scaledDigits(value, zdft.ConvertToDecimalPlaces)
+	if err != nil {
+		return nil, err
+	}
+	if width > 0 {
+		if len(digits) > width {
+			return nil, fmt.Errorf("Value %v needs %d bytes, wider than field width %d", value, len(digits), width)
+		}
+		digits = strings.Repeat("0", width-len(digits)) + digits
+	}
+	data := []byte(digits)
+	if last := len(data) - 1; negative {
+		if data[last] == '0' {
+			data[last] = '}'
+		} else {
+			data[last] = 'J' + data[last] - '1'
+		}
+	}
+	return data, nil
+}
+
+//zonedSignDigit returns the digit & sign overpunched in the last byte of a
+//zoned decimal.
+func zonedSignDigit(b byte) (digit byte, negative bool, ok bool) {
+	switch {
+	case b >= '0' && b <= '9', b >= 0xF0 && b <= 0xF9, b >= 0xC0 && b <= 0xC9:
+		return b & 0x0F, false, true
+	case b >= 0xD0 && b <= 0xD9, b >= 'p' && b <= 'y':
+		return b & 0x0F, true, true
+	case b == '{':
+		return 0, false, true
+	case b == '}':
+		return 0, true, true
+	case b >= 'A' && b <= 'I':
+		return b - 'A' + 1, false, true
+	case b >= 'J' && b <= 'R':
+		return b - 'J' + 1, true, true
+	}
+	return 0, false, false
+}
+
+/////////
+//BINARY
+/////////
+func init() {
+	FieldTypeRegistry["Binary"] = FieldTypeUnmarshalFunc(func(data []byte) (FieldType, error) {
+		bft := BinaryFieldType{}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &bft); err != nil {
+				return nil, err
+			}
+		}
+		return bft, nil
+	})
+}
+
+//BinaryFieldType is a RawFieldType which produces Fields containing float64
+//values from big-endian binary (COMP) integers of 1 to 8 bytes. Signed integers
+//are two's complement.
+type BinaryFieldType struct {
+	ConvertToDecimalPlaces int
+	Signed                 bool
+}
+
+//GetValue returns a field containing a float64 value read from the bytes of data.
+func (bft BinaryFieldType) GetValue(data string) (interface{}, error) {
+	return bft.GetRawValue([]byte(data))
+}
+
+//GetRawValue returns a field containing a float64 value.
+func (bft BinaryFieldType) GetRawValue(data []byte) (interface{}, error) {
+	if len(data) == 0 || len(data) > 8 {
+		return nil, fmt.Errorf("Binary fields must be 1 to 8 bytes, got %d", len(data))
+	}
+	var uval uint64
+	for _, b := range data {
+		uval = uval<<8 | uint64(b)
+	}
+	val := float64(uval)
+	if bft.Signed && data[0]&0x80 != 0 {
+		//Sign extend the two's complement value.
+		shift := uint(64 - 8*len(data))
+		val = float64(int64(uval<<shift) >> shift)
+	}
+	return val / math.Pow(10, float64(bft.ConvertToDecimalPlaces)), nil
+}
+
+//FormatValue returns the big-endian bytes of a Binary Field in as few bytes as
+//possible.
+func (bft BinaryFieldType) FormatValue(value interface{}) (string, error) {
+	data, err := bft.FormatRawValue(value, 0)
+	return string(data), err
+}
+
+//FormatRawValue returns the big-endian bytes of a Binary Field in width bytes
+//(or as few as possible if width is 0), sign extending negative values.
+func (bft BinaryFieldType) FormatRawValue(value interface{}, width int) ([]byte, error) {
+	val, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("Expected a float64 value, got %T", value)
+	}
+	val = math.Round(val * math.Pow(10, float64(bft.ConvertToDecimalPlaces)))
+	fits := func(size int) bool {
+		if bft.Signed {
+			limit := math.Ldexp(1, 8*size-1)
+			return val >= -limit && val < limit
+		}
+		return val >= 0 && val < math.Ldexp(1, 8*size)
+	}
+	size := width
+	if size <= 0 {
+		for size = 1; size < 8 && !fits(size); size++ {
+		}
+	}
+	if size > 8 {
+		return nil, fmt.Errorf("Binary fields must be 1 to 8 bytes, got %d", size)
+	}
+	if !fits(size) {
+		return nil, fmt.Errorf("Value %v does not fit in %d bytes", value, size)
+	}
+	var uval uint64
+	if val < 0 {
+		uval = uint64(int64(val))
+	} else {
+		uval = uint64(val)
+	}
+	data := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		data[i] = byte(uval)
+		uval >>= 8
+	}
+	return data, nil
+}
@@ -0,0 +1,156 @@
+package sfr
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMainframeNumericFieldTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		fieldType RawFieldType
+		data      []byte
+		expected  float64
+		valid     bool
+	}{
+		{"packed positive", PackedDecimalFieldType{ConvertToDecimalPlaces: 2}, []byte{0x01, 0x23, 0x4C}, 12.34, true},
+		{"packed negative", PackedDecimalFieldType{}, []byte{0x12, 0x3D}, -123, true},
+		{"packed unsigned", PackedDecimalFieldType{}, []byte{0x5F}, 5, true},
+		{"packed bad digit", PackedDecimalFieldType{}, []byte{0x1A, 0x3C}, 0, false},
+		{"packed bad sign", PackedDecimalFieldType{}, []byte{0x12, 0x34}, 0, false},
+		{"zoned ascii", ZonedDecimalFieldType{ConvertToDecimalPlaces: 2}, []byte("0012345"), 123.45, true},
+		{"zoned ascii positive", ZonedDecimalFieldType{}, []byte("12E"), 125, true},
+		{"zoned ascii negative", ZonedDecimalFieldType{}, []byte("12}"), -120, true},
+		{"zoned ebcdic negative", ZonedDecimalFieldType{ConvertToDecimalPlaces: 1}, []byte{0xF1, 0xF2, 0xD5}, -12.5, true},
+		{"zoned ebcdic positive", ZonedDecimalFieldType{}, []byte{0xF1, 0xC2}, 12, true},
+		{"zoned bad digit", ZonedDecimalFieldType{}, []byte("1X3"), 0, false},
+		{"zoned bad sign", ZonedDecimalFieldType{}, []byte("12#"), 0, false},
+		{"binary unsigned", BinaryFieldType{}, []byte{0xFF, 0xFE}, 65534, true},
+		{"binary signed", BinaryFieldType{Signed: true}, []byte{0xFF, 0xFE}, -2, true},
+		{"binary decimals", BinaryFieldType{Signed: true, ConvertToDecimalPlaces: 2}, []byte{0x00, 0x00, 0x30, 0x39}, 123.45, true},
+		{"binary too long", BinaryFieldType{}, make([]byte, 9), 0, false},
+	}
+	for _, test := range tests {
+		val, err := test.fieldType.GetRawValue(test.data)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if test.valid && val != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, val)
+		}
+	}
+}
+
+func TestMainframeNumericFormatValue(t *testing.T) {
+	type rawFormatter interface {
+		RawFieldType
+		RawFieldFormatter
+	}
+	tests := []struct {
+		name      string
+		fieldType rawFormatter
+		value     float64
+		width     int
+		expected  []byte
+	}{
+		{"packed positive", PackedDecimalFieldType{ConvertToDecimalPlaces: 2}, 12.34, 0, []byte{0x01, 0x23, 0x4C}},
+		{"packed negative", PackedDecimalFieldType{}, -123, 0, []byte{0x12, 0x3D}},
+		{"packed width", PackedDecimalFieldType{}, 5, 3, []byte{0x00, 0x00, 0x5C}},
+		{"zoned positive", ZonedDecimalFieldType{ConvertToDecimalPlaces: 2}, 123.45, 7, []byte("0012345")},
+		{"zoned negative", ZonedDecimalFieldType{}, -125, 0, []byte("12N")},
+		{"zoned negative zero digit", ZonedDecimalFieldType{ConvertToDecimalPlaces: 1}, -12, 4, []byte("012}")},
+		{"binary unsigned", BinaryFieldType{}, 65534, 0, []byte{0xFF, 0xFE}},
+		{"binary signed", BinaryFieldType{Signed: true}, -2, 4, []byte{0xFF, 0xFF, 0xFF, 0xFE}},
+		{"binary decimals", BinaryFieldType{Signed: true, ConvertToDecimalPlaces: 2}, 123.45, 0, []byte{0x30, 0x39}},
+	}
+	for _, test := range tests {
+		data, err := test.fieldType.FormatRawValue(test.value, test.width)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(data, test.expected) {
+			t.Errorf("%s: expected % X, got % X", test.name, test.expected, data)
+		}
+		if val, err := test.fieldType.GetRawValue(data); err != nil || val != test.value {
+			t.Errorf("%s: expected %v to read back, got %v (%v)", test.name, test.value, val, err)
+		}
+	}
+	//Values which do not fit the field are rejected.
+	if _, err := (PackedDecimalFieldType{}).FormatRawValue(12345.0, 2); err == nil {
+		t.Error("Expected an error formatting 12345 as a 2 byte PackedDecimal")
+	}
+	if _, err := (BinaryFieldType{}).FormatRawValue(-1.0, 2); err == nil {
+		t.Error("Expected an error formatting -1 as an unsigned Binary")
+	}
+}
+
+const mainframeCfg = `
+{
+	"SplitOnRecordName": "Account",
+	"Encoding": "CP037",
+	"RecordLength": 12,
+	"RecordDefinitions": [
+		{
+			"Name": "Account",
+			"ReaderName": "FixedWidth",
+			"RecordReader": {
+				"Coordinates": [
+					{"Start": 0, "End": 4},
+					{"Start": 4, "End": 7},
+					{"Start": 7, "End": 9},
+					{"Start": 9, "End": 12}
+				]
+			},
+			"FieldDefinitions": [
+				{"Name": "ID", "TypeName": "String"},
+				{"Name": "Balance", "TypeName": "PackedDecimal", "FieldType": {"ConvertToDecimalPlaces": 2}},
+				{"Name": "Count", "TypeName": "Binary", "FieldType": {"Signed": true}},
+				{"Name": "Adjustment", "TypeName": "ZonedDecimal"}
+			]
+		}
+	]
+}
+`
+
+func TestParseMainframeRecords(t *testing.T) {
+	var data []byte
+	data = append(data, encodeCP037("A001")...)
+	data = append(data, 0x12, 0x34, 0x5C, 0xFF, 0xFF, 0xF0, 0xF4, 0xD2)
+	data = append(data, encodeCP037("A002")...)
+	data = append(data, 0x12, 0x3A, 0x5C, 0x00, 0x01, 0xF0, 0xF0, 0xC1)
+
+	records := make([]*Record, 0)
+	var fieldErr error
+	p, err := NewParser(ioutil.NopCloser(bytes.NewReader([]byte(mainframeCfg))),
+		func(record *Record) error {
+			records = append(records, record)
+			return nil
+		},
+		func(err error) error {
+			if err != nil && fieldErr == nil {
+				fieldErr = err
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Parse(ioutil.NopCloser(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	fields := records[0].Fields
+	if fields[0].Value != "A001" || fields[1].Value != 123.45 || fields[2].Value != -1.0 || fields[3].Value != -42.0 {
+		t.Errorf("Unexpected fields %+v", fields)
+	}
+	var fpe FieldParseError
+	if !errors.As(fieldErr, &fpe) || fpe.FieldName != "Balance" || fpe.LineNum != 2 || fpe.Snippet != "12 3A 5C" {
+		t.Errorf("Expected a FieldParseError for Balance, got %v", fieldErr)
+	}
+}
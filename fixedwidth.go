@@ -1,8 +1,10 @@
 package sfr
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"strings"
 )
 
 const fixedWidthRecordReaderName = "FixedWidth"
@@ -25,15 +27,102 @@ type FixedWidthRecordReader struct {
 
 //Read splits record based on the configured Coordinates.
 func (fwr FixedWidthRecordReader) Read(data []byte) (values []string, err error) {
+	values, _, err = fwr.ReadSpans(data)
+	return values, err
+}
+
+//ReadSpans splits record based on the configured Coordinates, returning the
+//Coordinates as the span of each value.
+func (fwr FixedWidthRecordReader) ReadSpans(data []byte) (values []string, spans []FieldSpan, err error) {
 	values = make([]string, len(fwr.Coordinates))
+	spans = make([]FieldSpan, len(fwr.Coordinates))
 	for i, coord := range fwr.Coordinates {
+		if coord.End > len(data) {
+			return values[:i], spans[:i], fmt.Errorf("Record length %d is shorter than field end %d", len(data), coord.End)
+		}
 		values[i] = string(data[coord.Start:coord.End])
+		spans[i] = FieldSpan{Start: coord.Start, End: coord.End}
+	}
+	return values, spans, nil
+}
+
+//Write places each value within the configured Coordinates, padding &
+//justifying it to fill the width of the field.
+func (fwr FixedWidthRecordReader) Write(values []string) (data []byte, err error) {
+	if len(values) != len(fwr.Coordinates) {
+		return nil, fmt.Errorf("Expected %d values, got %d", len(fwr.Coordinates), len(values))
+	}
+	width := 0
+	for _, coord := range fwr.Coordinates {
+		if coord.End > width {
+			width = coord.End
+		}
+	}
+	data = bytes.Repeat([]byte(" "), width)
+	for i, coord := range fwr.Coordinates {
+		val, err := coord.pad(values[i])
+		if err != nil {
+			return nil, err
+		}
+		copy(data[coord.Start:coord.End], val)
+	}
+	return data, nil
+}
+
+//FieldWidths returns the width in bytes of each of the Coordinates.
+func (fwr FixedWidthRecordReader) FieldWidths() []int {
+	widths := make([]int, len(fwr.Coordinates))
+	for i, coord := range fwr.Coordinates {
+		widths[i] = coord.End - coord.Start
+	}
+	return widths
+}
+
+//ReadEncoded splits the original bytes of a record based on the configured
+//Coordinates & decodes each value using enc.
+func (fwr FixedWidthRecordReader) ReadEncoded(data []byte, enc Encoding) (values []string, spans []FieldSpan, err error) {
+	values, spans, err = fwr.ReadSpans(data)
+	for i, val := range values {
+		decoded, decErr := enc.Decode([]byte(val))
+		if decErr != nil {
+			return values[:i], spans[:i], decErr
+		}
+		values[i] = string(decoded)
 	}
-	return values, nil
+	return values, spans, err
 }
 
 //FixedWidthFieldCoordinate defines the start & end indices of a field within a record,
+//Justify ("Left" or "Right", default "Left") & Pad (default " ") are used when
+//writing values narrower than the field.
 type FixedWidthFieldCoordinate struct {
-	Start int
-	End   int
+	Start   int
+	End     int
+	Justify string `json:",omitempty"`
+	Pad     string `json:",omitempty"`
+}
+
+//pad justifies val within the width of the coordinate.
+func (coord FixedWidthFieldCoordinate) pad(val string) (string, error) {
+	width := coord.End - coord.Start
+	if len(val) > width {
+		return "", fmt.Errorf("Value \"%s\" is wider than field width %d", val, width)
+	}
+	pad := coord.Pad
+	if pad == "" {
+		pad = " "
+	}
+	padding := strings.Repeat(pad[:1], width-len(val))
+	switch coord.Justify {
+	case "", "Left":
+		return val + padding, nil
+	case "Right":
+		//Keep the sign in front of zero padding so the value can be read back.
+		if pad[0] == '0' && len(val) > 0 && (val[0] == '-' || val[0] == '+') {
+			return val[:1] + padding + val[1:], nil
+		}
+		return padding + val, nil
+	default:
+		return "", ConfigurationError(fmt.Errorf("Invalid Justify \"%s\"", coord.Justify))
+	}
 }
@@ -0,0 +1,233 @@
+package sfr
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+//Framing
+//The Parser divides its source into records before they are matched against
+//RecordDefinitions & read by RecordReaders:
+//1. By default, each line is a record. Lines end in "\n" or "\r\n" (the
+//   terminator is not included in the record).
+//2. If RecordTerminator is set, records end in that exact sequence of bytes
+//   (for example "~" or "\r") which is not included in the record.
+//3. If RecordLength is set, each record is exactly RecordLength bytes with no
+//   terminator (the last record may be shorter).
+//Multi-line Delimited records are rejoined using the terminator which split
+//them (including the "\r" of "\r\n" line endings).
+//Records are limited to MaxRecordSize bytes unless it is -1, in which case
+//they are read in chunks by a streamScanner.
+
+//recordScanner divides a source into records.
+type recordScanner interface {
+	Scan() bool
+	Bytes() []byte
+	Err() error
+	//Offset returns the byte offset of the record returned by Bytes.
+	Offset() int64
+	//Terminator returns the terminator which ended the record returned by
+	//Bytes (empty for the last record if it had none & for fixed length records).
+	Terminator() []byte
+}
+
+//newRecordScanner returns a recordScanner for the Parser's framing.
+func (p *Parser) newRecordScanner(source io.Reader) recordScanner {
+	if p.MaxRecordSize < 0 {
+		return &streamScanner{
+			r:            bufio.NewReader(source),
+			recordLength: p.RecordLength,
+			terminator:   []byte(p.recordTerminator()),
+		}
+	}
+	s := &bufferedScanner{Scanner: bufio.NewScanner(source), terminator: []byte(p.recordTerminator())}
+	if p.MaxRecordSize > 0 {
+		s.Buffer(make([]byte, 0, minInt(p.MaxRecordSize, 4096)), p.MaxRecordSize)
+	}
+	split := p.splitFunc()
+	s.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		advance, token, err = split(data, atEOF)
+		if token != nil {
+			s.offset = s.consumed
+		}
+		s.consumed += int64(advance)
+		return
+	})
+	return s
+}
+
+//bufferedScanner is a recordScanner which holds each record in the buffer of
+//a bufio.Scanner.
+type bufferedScanner struct {
+	*bufio.Scanner
+	//consumed is the number of bytes read by the split function & offset is
+	//the offset of the current record.
+	consumed int64
+	offset   int64
+	//terminator is the terminator of the Parser's framing.
+	terminator []byte
+}
+
+//Offset returns the byte offset of the current record.
+func (s *bufferedScanner) Offset() int64 {
+	return s.offset
+}
+
+//Terminator returns the terminator which ended the current record.
+func (s *bufferedScanner) Terminator() []byte {
+	return removedTerminator(s.terminator, s.consumed-s.offset-int64(len(s.Bytes())))
+}
+
+//removedTerminator returns the n bytes removed from the end of a record framed
+//by terminator.
+func removedTerminator(terminator []byte, n int64) []byte {
+	switch {
+	case n <= 0:
+		return nil
+	case string(terminator) == "\n" && n == 2:
+		return []byte("\r\n")
+	}
+	return terminator
+}
+
+//streamScanner is a recordScanner which reads records of any size in chunks.
+type streamScanner struct {
+	r            *bufio.Reader
+	recordLength int
+	terminator   []byte
+	record       []byte
+	err          error
+	consumed     int64
+	offset       int64
+}
+
+//Scan reads the next record, returning false at EOF or on error.
+func (s *streamScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	s.record = s.record[:0]
+	var err error
+	if s.recordLength > 0 {
+		s.record = append(s.record, make([]byte, s.recordLength)...)
+		var n int
+		n, err = io.ReadFull(s.r, s.record)
+		s.record = s.record[:n]
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+	} else {
+		last := s.terminator[len(s.terminator)-1]
+		for {
+			var chunk []byte
+			chunk, err = s.r.ReadSlice(last)
+			s.record = append(s.record, chunk...)
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err != nil || bytes.HasSuffix(s.record, s.terminator) {
+				break
+			}
+		}
+	}
+	s.offset = s.consumed
+	s.consumed += int64(len(s.record))
+	if err != nil {
+		s.err = err
+		if len(s.record) == 0 {
+			return false
+		}
+	}
+	if s.recordLength == 0 {
+		s.record = bytes.TrimSuffix(s.record, s.terminator)
+		if string(s.terminator) == "\n" {
+			//Default line framing also accepts "\r\n".
+			s.record = bytes.TrimSuffix(s.record, []byte("\r"))
+		}
+	}
+	return true
+}
+
+//Bytes returns the current record.
+func (s *streamScanner) Bytes() []byte {
+	return s.record
+}
+
+//Err returns the first error other than io.EOF.
+func (s *streamScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+//Offset returns the byte offset of the current record.
+func (s *streamScanner) Offset() int64 {
+	return s.offset
+}
+
+//Terminator returns the terminator which ended the current record.
+func (s *streamScanner) Terminator() []byte {
+	if s.recordLength > 0 {
+		return nil
+	}
+	return removedTerminator(s.terminator, s.consumed-s.offset-int64(len(s.record)))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+//splitFunc returns the bufio.SplitFunc for the Parser's framing.
+func (p *Parser) splitFunc() bufio.SplitFunc {
+	switch {
+	case p.RecordLength > 0:
+		return scanFixedLength(p.RecordLength)
+	case p.RecordTerminator != "":
+		return scanTerminated([]byte(p.RecordTerminator))
+	default:
+		return bufio.ScanLines
+	}
+}
+
+//recordTerminator returns the terminator removed from the end of each record.
+func (p *Parser) recordTerminator() string {
+	switch {
+	case p.RecordLength > 0:
+		return ""
+	case p.RecordTerminator != "":
+		return p.RecordTerminator
+	default:
+		return "\n"
+	}
+}
+
+//scanFixedLength returns a bufio.SplitFunc producing records of length bytes.
+func scanFixedLength(length int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if len(data) >= length {
+			return length, data[:length], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+//scanTerminated returns a bufio.SplitFunc producing records ending in terminator.
+func scanTerminated(terminator []byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if i := bytes.Index(data, terminator); i >= 0 {
+			return i + len(terminator), data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
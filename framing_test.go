@@ -0,0 +1,104 @@
+package sfr
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+//parseInvoices parses data using JoinCfg with the framing applied by frame.
+func parseInvoices(t *testing.T, data string, frame func(p *Parser)) []*Record {
+	invoices := make([]*Record, 0)
+	p, err := NewParser(
+		ioutil.NopCloser(strings.NewReader(JoinCfg)),
+		func(record *Record) error {
+			invoices = append(invoices, record)
+			return nil
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame(&p)
+	if err = p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	return invoices
+}
+
+func TestFixedLengthRecords(t *testing.T) {
+	var data strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(HierarchyData), "\n") {
+		line = strings.Replace(line, "INV22222222", "INV22222", 1)
+		data.WriteString(line + strings.Repeat(" ", 30-len(line)))
+	}
+	invoices := parseInvoices(t, data.String(), func(p *Parser) { p.RecordLength = 30 })
+	if len(invoices) != 2 {
+		t.Fatalf("Expected 2 invoices, got %d", len(invoices))
+	}
+	dist := invoices[1].Children[0].Children[0]
+	account, _ := dist.GetField("Account")
+	if account.Value != "ACCTNUM221" || dist.LineNum != 8 || dist.Offset != 210 {
+		t.Errorf("Unexpected record %s on line %d at offset %d", account.Value, dist.LineNum, dist.Offset)
+	}
+}
+
+func TestRecordTerminator(t *testing.T) {
+	data := "010~INV1~12345~17-JUL-2019|030~0001~\"Split|Description\"|033ACCTNUM001|"
+	invoices := parseInvoices(t, data, func(p *Parser) { p.RecordTerminator = "|" })
+	line := invoices[0].Children[0]
+	desc, _ := line.GetField("Description")
+	if desc.Value != "Split|Description" || len(line.Children) != 1 {
+		t.Errorf("Unexpected line %q with %d children", desc.Value, len(line.Children))
+	}
+}
+
+func TestLongRecords(t *testing.T) {
+	longDesc := strings.Repeat("x", 100000)
+	data := "010~INV1~12345~17-JUL-2019\r\n030~0001~" + longDesc + "\r\n033ACCTNUM001\r\n"
+
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(JoinCfg)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Parse(ioutil.NopCloser(strings.NewReader(data)))
+	srcErr, ok := err.(SourceError)
+	if !ok || srcErr.LineNum != 2 {
+		t.Errorf("Expected a SourceError on line 2, got %v", err)
+	}
+
+	for _, maxRecordSize := range []int{200000, -1} {
+		invoices := parseInvoices(t, data, func(p *Parser) { p.MaxRecordSize = maxRecordSize })
+		line := invoices[0].Children[0]
+		desc, _ := line.GetField("Description")
+		if desc.Value != longDesc || len(line.Children) != 1 || line.Children[0].Offset != 100039 {
+			t.Errorf("MaxRecordSize %d: unexpected line", maxRecordSize)
+		}
+	}
+}
+
+func TestStreamFraming(t *testing.T) {
+	stream := func(p *Parser) {
+		p.MaxRecordSize = -1
+		p.RecordTerminator = "|"
+	}
+	invoices := parseInvoices(t, "010~INV1~12345~17-JUL-2019|030~0001~\"Split|Description\"|033ACCTNUM001", stream)
+	line := invoices[0].Children[0]
+	desc, _ := line.GetField("Description")
+	if desc.Value != "Split|Description" || len(line.Children) != 1 {
+		t.Errorf("Unexpected line %q with %d children", desc.Value, len(line.Children))
+	}
+
+	fixed := func(p *Parser) {
+		p.MaxRecordSize = -1
+		p.RecordLength = 30
+	}
+	invoices = parseInvoices(t, "010~INV12345~12345~17-JUL-2019030~0001~Invoice One, Line One033ACCTNUM001", fixed)
+	if len(invoices) != 1 || invoices[0].Children[0].Children[0].LineNum != 3 {
+		t.Errorf("Unexpected invoices %v", invoices)
+	}
+}
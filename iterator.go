@@ -0,0 +1,104 @@
+package sfr
+
+import (
+	"context"
+	"io"
+)
+
+//RecordIterator is a pull-based alternative to the RecordProcessor callback.
+//It returns each Record whose name matches SplitOnRecordName (or a name in
+//RecordProcessors) in turn. The Parser's RecordProcessors are not called, but
+//its ErrorHandler is.
+//
+//	it := p.Records(source)
+//	defer it.Close()
+//	for it.Next() {
+//		record := it.Record()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type RecordIterator struct {
+	source io.ReadCloser
+	ps     *parseState
+	record *Record
+	err    error
+	closed bool
+}
+
+//Records returns a RecordIterator which reads Records from source.
+//The source is closed when the iterator is exhausted, fails or is closed.
+func (p *Parser) Records(source io.ReadCloser) *RecordIterator {
+	return p.RecordsContext(context.Background(), source)
+}
+
+//RecordsContext returns a RecordIterator which stops with a ContextError when
+//ctx is done.
+func (p *Parser) RecordsContext(ctx context.Context, source io.ReadCloser) *RecordIterator {
+	ps, err := p.newParseState(ctx, source)
+	if err != nil {
+		source.Close()
+		return &RecordIterator{source: source, err: err, closed: true}
+	}
+	return &RecordIterator{
+		source: source,
+		ps:     ps,
+	}
+}
+
+//Next advances the iterator to the next Record, returning false when there are
+//no more Records or an error occurred.
+func (it *RecordIterator) Next() bool {
+	it.record = nil
+	if it.closed {
+		return false
+	}
+	for {
+		record, err := it.ps.next()
+		if err == io.EOF {
+			it.Close()
+			return false
+		}
+		if err != nil {
+			it.err = err
+			it.Close()
+			return false
+		}
+		//The final split record is nil if none were found in the source.
+		if record != nil {
+			it.record = record
+			return true
+		}
+	}
+}
+
+//Record returns the current Record.
+func (it *RecordIterator) Record() *Record {
+	return it.record
+}
+
+//UnmatchedLineCount returns the number of lines read so far which matched no
+//RecordDefinition.
+func (it *RecordIterator) UnmatchedLineCount() int {
+	if it.ps == nil {
+		return 0
+	}
+	return it.ps.unmatched
+}
+
+//Err returns the first error encountered by the iterator.
+func (it *RecordIterator) Err() error {
+	return it.err
+}
+
+//Close releases the source. It is safe to call Close more than once so it
+//can be deferred to stop early.
+func (it *RecordIterator) Close() error {
+	if it.closed {
+		return nil
+	}
+	it.closed = true
+	it.ps.close()
+	return it.source.Close()
+}
@@ -0,0 +1,62 @@
+package sfr
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (cr *closeRecorder) Close() error {
+	cr.closed = true
+	return nil
+}
+
+func TestRecordIterator(t *testing.T) {
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(JoinCfg)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &closeRecorder{Reader: strings.NewReader(HierarchyData)}
+	it := p.Records(source)
+	invoiceNumbers := make([]interface{}, 0)
+	for it.Next() {
+		invnum, err := it.Record().GetField("InvoiceNumber")
+		if err != nil {
+			t.Fatal(err)
+		}
+		invoiceNumbers = append(invoiceNumbers, invnum.Value)
+	}
+	if err = it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(invoiceNumbers) != 2 || invoiceNumbers[0] != "INV98765" || invoiceNumbers[1] != "INV22222222" {
+		t.Errorf("Unexpected invoice numbers %v", invoiceNumbers)
+	}
+	if !source.closed {
+		t.Error("Expected source to be closed at EOF")
+	}
+}
+
+func TestRecordIteratorEarlyClose(t *testing.T) {
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(JoinCfg)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &closeRecorder{Reader: strings.NewReader(HierarchyData)}
+	it := p.Records(source)
+	if !it.Next() {
+		t.Fatal("Expected a record")
+	}
+	it.Close()
+	if !source.closed {
+		t.Error("Expected source to be closed")
+	}
+	if it.Next() {
+		t.Error("Expected no records after Close")
+	}
+}
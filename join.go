@@ -0,0 +1,223 @@
+package sfr
+
+import (
+	"fmt"
+	"strings"
+)
+
+//joinIndex indexes the Records of a parent RecordDefinition by the values of
+//the fields a child RecordDefinition joins on.
+type joinIndex struct {
+	//recordName is the name of the Records indexed.
+	recordName string
+	fieldNames []string
+	//records holds Records above the split record, which are kept until the
+	//next Record above them in the hierarchy is read (or until there are more
+	//than the Parser's JoinIndexSize, when the oldest are dropped).
+	records map[string]indexedRecord
+	//order holds the keys of records in the order they were added.
+	order []indexedKey
+	seq   int
+	//splitRecords holds Records within the current split record, which are
+	//dropped when the next split record starts.
+	splitRecords map[string]*Record
+}
+
+//indexedRecord is a Record in the records of a joinIndex. seq identifies when
+//it was added.
+type indexedRecord struct {
+	rec *Record
+	seq int
+}
+
+//indexedKey is an entry in the order of a joinIndex.
+type indexedKey struct {
+	key string
+	seq int
+}
+
+//newJoinIndexes returns the joinIndexes required by RecordDefinitions with
+//JoinOnFieldNames, keyed by parent record name.
+func newJoinIndexes(recDefs []*RecordDefinition) map[string][]*joinIndex {
+	indexes := make(map[string][]*joinIndex)
+	for _, recDef := range recDefs {
+		if len(recDef.JoinOnFieldNames) == 0 {
+			continue
+		}
+		fieldNames := recDef.parentJoinFieldNames()
+		if findJoinIndex(indexes, recDef.ParentRecordName, fieldNames) != nil {
+			continue
+		}
+		indexes[recDef.ParentRecordName] = append(indexes[recDef.ParentRecordName], &joinIndex{
+			recordName:   recDef.ParentRecordName,
+			fieldNames:   fieldNames,
+			records:      make(map[string]indexedRecord),
+			splitRecords: make(map[string]*Record),
+		})
+	}
+	return indexes
+}
+
+//findJoinIndex returns the joinIndex of parentName on fieldNames, or nil.
+func findJoinIndex(indexes map[string][]*joinIndex, parentName string, fieldNames []string) *joinIndex {
+	for _, idx := range indexes[parentName] {
+		if strings.Join(idx.fieldNames, "\x00") == strings.Join(fieldNames, "\x00") {
+			return idx
+		}
+	}
+	return nil
+}
+
+//add indexes rec if it has values for each of the index's fields. If more
+//than limit Records above the split record are indexed (& limit is positive),
+//the oldest are dropped.
+func (idx *joinIndex) add(rec *Record, limit int) {
+	key, ok := joinKey(rec, idx.fieldNames)
+	if !ok {
+		return
+	}
+	if rec.isWithinSplit {
+		idx.splitRecords[key] = rec
+		return
+	}
+	idx.seq++
+	idx.records[key] = indexedRecord{rec: rec, seq: idx.seq}
+	idx.order = append(idx.order, indexedKey{key: key, seq: idx.seq})
+	for limit > 0 && len(idx.records) > limit {
+		oldest := idx.order[0]
+		idx.order = idx.order[1:]
+		if idx.records[oldest.key].seq == oldest.seq {
+			delete(idx.records, oldest.key)
+		}
+	}
+	if len(idx.order) > 2*len(idx.records)+16 {
+		//Drop the entries of keys which have been indexed again.
+		order := make([]indexedKey, 0, len(idx.records))
+		for _, k := range idx.order {
+			if idx.records[k.key].seq == k.seq {
+				order = append(order, k)
+			}
+		}
+		idx.order = order
+	}
+}
+
+//reset drops the Records above the split record.
+func (idx *joinIndex) reset() {
+	if len(idx.records) > 0 {
+		idx.records = make(map[string]indexedRecord)
+		idx.order = nil
+	}
+}
+
+//get returns the last Record indexed with key.
+func (idx *joinIndex) get(key string) (*Record, bool) {
+	if rec, ok := idx.splitRecords[key]; ok {
+		return rec, true
+	}
+	indexed, ok := idx.records[key]
+	return indexed.rec, ok
+}
+
+//joinKey returns the values of the named fields of rec as a single key. It
+//returns false if rec doesn't have one of the fields.
+func joinKey(rec *Record, fieldNames []string) (string, bool) {
+	values := make([]string, len(fieldNames))
+	for i, name := range fieldNames {
+		fld, ok := rec.field(name)
+		if !ok {
+			return "", false
+		}
+		values[i] = fmt.Sprint(fld.Value)
+	}
+	return strings.Join(values, "\x00"), true
+}
+
+//field returns the Field of rec with the given name.
+func (rec *Record) field(name string) (Field, bool) {
+	for _, fld := range rec.Fields {
+		if fld.Name == name {
+			return fld, true
+		}
+	}
+	return Field{}, false
+}
+
+//parentJoinFieldNames returns the names of the parent's fields which are
+//matched against JoinOnFieldNames.
+func (rd *RecordDefinition) parentJoinFieldNames() []string {
+	if len(rd.ParentJoinOnFieldNames) > 0 {
+		return rd.ParentJoinOnFieldNames
+	}
+	return rd.JoinOnFieldNames
+}
+
+//indexRecord adds rec to the joinIndexes of its RecordDefinition.
+func (ps *parseState) indexRecord(rec *Record) {
+	for _, idx := range ps.joinIndexes[rec.Name] {
+		idx.add(rec, ps.p.joinIndexSize())
+	}
+}
+
+//closeJoinIndexes drops the Records indexed beneath the previous Record named
+//name (as rec, the next Record named name, replaces it as their ancestor).
+func (ps *parseState) closeJoinIndexes(name string) {
+	for _, indexes := range ps.joinIndexes {
+		for _, idx := range indexes {
+			if ps.hasAncestor(idx.recordName, name) {
+				idx.reset()
+			}
+		}
+	}
+}
+
+//joinIndexSize returns the limit on the Records above the split record kept
+//by each joinIndex (0 if there is none).
+func (p *Parser) joinIndexSize() int {
+	switch {
+	case p.JoinIndexSize < 0:
+		return 0
+	case p.JoinIndexSize == 0:
+		return defaultJoinIndexSize
+	}
+	return p.JoinIndexSize
+}
+
+//defaultJoinIndexSize is the default JoinIndexSize.
+const defaultJoinIndexSize = 10000
+
+//resetSplitIndexes drops the Records indexed within the previous split record.
+func (ps *parseState) resetSplitIndexes() {
+	for _, indexes := range ps.joinIndexes {
+		for _, idx := range indexes {
+			if len(idx.splitRecords) > 0 {
+				idx.splitRecords = make(map[string]*Record)
+			}
+		}
+	}
+}
+
+//parent returns the Record rec should be attached to. Records of a
+//RecordDefinition with JoinOnFieldNames are attached to the last parent whose
+//fields have the same values, other Records are attached to the last parent
+//read. If there is no parent, a message describing why is returned.
+func (ps *parseState) parent(recDef *RecordDefinition, rec *Record) (*Record, string) {
+	if len(recDef.JoinOnFieldNames) == 0 {
+		if parent, ok := ps.lastRecords[recDef.ParentRecordName]; ok {
+			return parent, ""
+		}
+		return nil, fmt.Sprintf("No available parent record \"%s\"", recDef.ParentRecordName)
+	}
+	key, ok := joinKey(rec, recDef.JoinOnFieldNames)
+	if !ok {
+		return nil, fmt.Sprintf("Missing JoinOnFieldNames %v", recDef.JoinOnFieldNames)
+	}
+	idx := findJoinIndex(ps.joinIndexes, recDef.ParentRecordName, recDef.parentJoinFieldNames())
+	if idx == nil {
+		return nil, fmt.Sprintf("No parent record \"%s\" to join to", recDef.ParentRecordName)
+	}
+	if parent, ok := idx.get(key); ok {
+		return parent, ""
+	}
+	return nil, fmt.Sprintf("No parent record \"%s\" with %v equal to %v", recDef.ParentRecordName, recDef.parentJoinFieldNames(), strings.Split(key, "\x00"))
+}
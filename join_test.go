@@ -0,0 +1,208 @@
+package sfr
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const joinCfg = `
+{
+	"SplitOnRecordName": "Header",
+	"RecordDefinitions": [
+		{
+			"Name": "Header",
+			"MatchExpression": "^H",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"},
+				{"Name": "PONumber", "TypeName": "String"}
+			]
+		},
+		{
+			"Name": "Line",
+			"ParentRecordName": "Header",
+			"MatchExpression": "^L",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"},
+				{"Name": "PONumber", "TypeName": "String"},
+				{"Name": "LineNumber", "TypeName": "Number"}
+			]
+		},
+		{
+			"Name": "Shipment",
+			"ParentRecordName": "Line",
+			"JoinOnFieldNames": ["PONumber", "ShipLineNumber"],
+			"ParentJoinOnFieldNames": ["PONumber", "LineNumber"],
+			"MatchExpression": "^S",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"},
+				{"Name": "PONumber", "TypeName": "String"},
+				{"Name": "ShipLineNumber", "TypeName": "Number"},
+				{"Name": "Quantity", "TypeName": "Number"}
+			]
+		}
+	]
+}
+`
+
+func TestJoinOnFieldNames(t *testing.T) {
+	data := strings.Join([]string{
+		"H,PO1",
+		"L,PO1,1",
+		"L,PO1,2",
+		"S,PO1,1,5",
+		"S,PO1,2,3",
+		"S,PO1,1,2",
+		"H,PO2",
+		"L,PO2,1",
+		"S,PO2,1,7",
+		"S,PO1,1,9",
+	}, "\n")
+	headers := make([]*Record, 0)
+	errs := make([]error, 0)
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(joinCfg)),
+		func(record *Record) error {
+			headers = append(headers, record)
+			return nil
+		},
+		func(err error) error {
+			if err != nil {
+				errs = append(errs, err)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 2 {
+		t.Fatalf("Expected 2 headers, got %d", len(headers))
+	}
+	lines := headers[0].Children
+	if len(lines) != 2 || len(lines[0].Children) != 2 || len(lines[1].Children) != 1 {
+		t.Fatalf("Unexpected PO1 hierarchy %+v", lines)
+	}
+	if lines[0].Children[1].Fields[3].Value != 2.0 || lines[1].Children[0].Fields[3].Value != 3.0 {
+		t.Errorf("Shipments attached to the wrong lines")
+	}
+	if len(headers[1].Children[0].Children) != 1 {
+		t.Errorf("Expected 1 shipment for PO2")
+	}
+	//PO1 is no longer within the split record.
+	var rpe RecordParseError
+	if len(errs) != 1 || !errors.As(errs[0], &rpe) || rpe.RecordName != "Shipment" || rpe.LineNum != 10 {
+		t.Errorf("Expected a RecordParseError for the last Shipment, got %v", errs)
+	}
+}
+
+func TestValidateJoinOnFieldNames(t *testing.T) {
+	cfg := strings.Replace(joinCfg, `"ParentJoinOnFieldNames": ["PONumber", "LineNumber"]`, `"ParentJoinOnFieldNames": ["PONumber"]`, 1)
+	_, err := NewParser(ioutil.NopCloser(strings.NewReader(cfg)), nil, nil)
+	var ces ConfigurationErrors
+	if !errors.As(err, &ces) || len(ces) != 1 {
+		t.Errorf("Expected 1 ConfigurationError, got %v", err)
+	}
+}
+
+const joinAboveSplitCfg = `
+{
+	"SplitOnRecordName": "Line",
+	"RecordDefinitions": [
+		{
+			"Name": "Batch",
+			"MatchExpression": "^B",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"}
+			]
+		},
+		{
+			"Name": "Header",
+			"ParentRecordName": "Batch",
+			"MatchExpression": "^H",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"},
+				{"Name": "PONumber", "TypeName": "String"}
+			]
+		},
+		{
+			"Name": "Line",
+			"ParentRecordName": "Header",
+			"JoinOnFieldNames": ["PONumber"],
+			"MatchExpression": "^L",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"},
+				{"Name": "PONumber", "TypeName": "String"}
+			]
+		}
+	]
+}
+`
+
+func TestJoinIndexEviction(t *testing.T) {
+	data := strings.Join([]string{
+		"B",
+		"H,PO1",
+		"H,PO2",
+		"L,PO1",
+		"L,PO2",
+		"B",
+		"H,PO3",
+		"L,PO1",
+		"L,PO3",
+	}, "\n")
+	for _, test := range []struct {
+		size       int
+		errorLines []int
+	}{
+		//PO1 is dropped by the second Batch.
+		{0, []int{8}},
+		//Only the last Header of each Batch is kept.
+		{1, []int{4, 8}},
+	} {
+		orphans := 0
+		errLines := make([]int, 0)
+		p, err := NewParser(ioutil.NopCloser(strings.NewReader(joinAboveSplitCfg)),
+			func(record *Record) error {
+				if record.Parent == nil {
+					orphans++
+				}
+				return nil
+			},
+			func(err error) error {
+				var rpe RecordParseError
+				if errors.As(err, &rpe) {
+					errLines = append(errLines, rpe.LineNum)
+				}
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.JoinIndexSize = test.size
+		if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(errLines) != fmt.Sprint(test.errorLines) {
+			t.Errorf("JoinIndexSize %d: expected errors on lines %v, got %v", test.size, test.errorLines, errLines)
+		}
+		if orphans != len(test.errorLines) {
+			t.Errorf("JoinIndexSize %d: expected %d Lines without a parent, got %d", test.size, len(test.errorLines), orphans)
+		}
+	}
+}
@@ -0,0 +1,511 @@
+package sfr
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	//StreamingJoinMode attaches each Record to its parent as it is read.
+	StreamingJoinMode = "Streaming"
+	//BufferedJoinMode buffers the Records of each section & attaches them to
+	//their parents once the section is complete.
+	BufferedJoinMode = "Buffered"
+)
+
+//bufferedRecord is a Record held by a joinBuffer, either in memory or in the
+//spill file.
+type bufferedRecord struct {
+	recDef *RecordDefinition
+	//rec is nil if the Record has been spilled to disk.
+	rec         *Record
+	lineNum     int
+	offset      int64
+	spillOffset int64
+	spillLen    int
+	//parent is the index of the parent Record within the section or -1.
+	parent int
+	//key holds the values of the JoinOnFieldNames of the Record.
+	key    string
+	hasKey bool
+	//children holds the indexes of the Records attached beneath this Record.
+	children []int
+	//occurs counts the Records attached beneath this Record by name (see
+	//MinOccurs & MaxOccurs).
+	occurs map[string]int
+	//size is the estimated bytes of memory held for this Record, of which
+	//recSize is released if the Record is spilled.
+	size    int64
+	recSize int64
+}
+
+//Approximate sizes in bytes of the structures held by a joinBuffer, used to
+//estimate its memory use.
+const (
+	recordOverhead         = 160
+	fieldOverhead          = 80
+	bufferedRecordOverhead = 160
+	mapEntryOverhead       = 48
+	sliceEntryOverhead     = 8
+)
+
+//recordSize estimates the bytes of memory used by rec & its Fields.
+func recordSize(rec *Record) int64 {
+	size := int64(recordOverhead + len(rec.Name) + len(rec.Raw))
+	for _, fld := range rec.Fields {
+		size += int64(fieldOverhead + len(fld.Name) + len(fld.TypeName))
+		switch v := fld.Value.(type) {
+		case string:
+			size += int64(len(v))
+		case []byte:
+			size += int64(len(v))
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+//joinSection holds the Records buffered until a section is complete.
+type joinSection struct {
+	records []*bufferedRecord
+	//carried is the number of Records at the start of records carried over
+	//from the previous section.
+	carried int
+	//last holds the index of the last Record read for each record name.
+	last map[string]int
+	//keys holds the index of the last Record read for each key of each joinIndex.
+	keys map[*joinIndex]map[string]int
+	//loaded holds ancestors read back from the spill file so that they are
+	//shared by all of their descendants.
+	loaded map[int]*Record
+}
+
+func newJoinSection() *joinSection {
+	return &joinSection{
+		records: make([]*bufferedRecord, 0),
+		last:    make(map[string]int),
+		keys:    make(map[*joinIndex]map[string]int),
+		loaded:  make(map[int]*Record),
+	}
+}
+
+//joinBuffer assembles the Record hierarchy of a Buffered join. Records are
+//added to the current section until a record named JoinSectionRecordName (or
+//the end of the source) completes it. The split records of the completed
+//section are then queued & their hierarchies built as they are returned.
+type joinBuffer struct {
+	ps      *parseState
+	section *joinSection
+	//ready is the completed section whose split records are queued.
+	ready *joinSection
+	queue []int
+	//size is the estimated bytes of memory held for the buffered Records.
+	size      int64
+	spill     *os.File
+	spillSize int64
+	//withinSplit is set for split records & their descendants.
+	withinSplit map[string]bool
+}
+
+func newJoinBuffer(ps *parseState) *joinBuffer {
+	jb := &joinBuffer{
+		ps:          ps,
+		section:     newJoinSection(),
+		withinSplit: make(map[string]bool),
+	}
+	for _, recDef := range ps.p.RecordDefinitions {
+		jb.withinSplit[recDef.Name] = ps.p.isSplitLevel(recDef.Name)
+		for _, ancestor := range ps.ancestors[recDef.Name] {
+			if ps.p.isSplitLevel(ancestor) {
+				jb.withinSplit[recDef.Name] = true
+			}
+		}
+	}
+	return jb
+}
+
+//add buffers a Record, completing the current section first if the Record
+//starts a new section.
+func (jb *joinBuffer) add(recDef *RecordDefinition, rec *Record, text []byte) error {
+	p := jb.ps.p
+	if recDef.Name == p.JoinSectionRecordName && len(jb.section.records) > jb.section.carried {
+		if err := jb.finishSection(); err != nil {
+			return err
+		}
+	}
+	s := jb.section
+	i := len(s.records)
+	br := &bufferedRecord{recDef: recDef, rec: rec, lineNum: rec.LineNum, offset: rec.Offset, parent: -1}
+	if len(recDef.JoinOnFieldNames) > 0 {
+		//Key joins are resolved when the section is complete.
+		br.key, br.hasKey = joinKey(rec, recDef.JoinOnFieldNames)
+	} else if recDef.ParentRecordName != "" {
+		parent, ok := s.last[recDef.ParentRecordName]
+		if !ok {
+			err := p.ErrorHandler(jb.ps.recordError(recDef.Name, rec.LineNum, text, fmt.Sprintf("No available parent record \"%s\"", recDef.ParentRecordName)))
+			if err != nil {
+				return err
+			}
+		} else {
+			br.parent = parent
+		}
+	}
+	br.size = int64(bufferedRecordOverhead + sliceEntryOverhead + len(br.key))
+	for _, idx := range jb.ps.joinIndexes[rec.Name] {
+		if key, ok := joinKey(rec, idx.fieldNames); ok {
+			if s.keys[idx] == nil {
+				s.keys[idx] = make(map[string]int)
+			}
+			s.keys[idx][key] = i
+			br.size += int64(mapEntryOverhead + len(key))
+		}
+	}
+	s.last[rec.Name] = i
+	s.records = append(s.records, br)
+	return jb.hold(br)
+}
+
+//hold accounts for the memory used by br, spilling its Record to disk if the
+//buffer is full.
+func (jb *joinBuffer) hold(br *bufferedRecord) error {
+	p := jb.ps.p
+	br.recSize = recordSize(br.rec)
+	br.size += br.recSize
+	jb.size += br.size
+	if p.JoinBufferSize <= 0 || jb.size <= p.JoinBufferSize {
+		return nil
+	}
+	if !p.JoinSpillToDisk {
+		return JoinBufferError{LineNum: br.lineNum, Size: p.JoinBufferSize}
+	}
+	if jb.spill == nil {
+		f, err := ioutil.TempFile(p.JoinSpillDirectory, "sfr-join-")
+		if err != nil {
+			return JoinBufferError{LineNum: br.lineNum, Size: p.JoinBufferSize, Err: err}
+		}
+		jb.spill = f
+	}
+	if err := jb.writeSpill(br, br.rec.Raw); err != nil {
+		return err
+	}
+	br.size -= br.recSize
+	jb.size -= br.recSize
+	br.rec = nil
+	return nil
+}
+
+//writeSpill appends the raw bytes of br to the spill file.
+func (jb *joinBuffer) writeSpill(br *bufferedRecord, raw []byte) error {
+	n, err := jb.spill.Write(raw)
+	if err != nil {
+		return JoinBufferError{LineNum: br.lineNum, Size: jb.ps.p.JoinBufferSize, Err: err}
+	}
+	br.spillOffset, br.spillLen = jb.spillSize, n
+	jb.spillSize += int64(n)
+	return nil
+}
+
+//resetSpill empties the spill file once the ready section has been released,
+//rewriting any Records of the current section which were spilled to it.
+func (jb *joinBuffer) resetSpill() error {
+	if jb.spill == nil || jb.spillSize == 0 {
+		return nil
+	}
+	spilled := make([]*bufferedRecord, 0)
+	raws := make([][]byte, 0)
+	for _, br := range jb.section.records {
+		if br.rec == nil {
+			raw, err := jb.raw(br)
+			if err != nil {
+				return err
+			}
+			spilled = append(spilled, br)
+			raws = append(raws, raw)
+		}
+	}
+	if err := jb.spill.Truncate(0); err != nil {
+		return JoinBufferError{LineNum: jb.ps.lineNum, Size: jb.ps.p.JoinBufferSize, Err: err}
+	}
+	if _, err := jb.spill.Seek(0, io.SeekStart); err != nil {
+		return JoinBufferError{LineNum: jb.ps.lineNum, Size: jb.ps.p.JoinBufferSize, Err: err}
+	}
+	jb.spillSize = 0
+	for i, br := range spilled {
+		if err := jb.writeSpill(br, raws[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//finishSection resolves the parents of the Records in the current section,
+//queues its split records & starts a new section, carrying over the last
+//Record of each RecordDefinition above the split record.
+func (jb *joinBuffer) finishSection() error {
+	ps := jb.ps
+	s := jb.section
+	for _, br := range s.records {
+		recDef := br.recDef
+		if len(recDef.JoinOnFieldNames) == 0 {
+			continue
+		}
+		msg := fmt.Sprintf("Missing JoinOnFieldNames %v", recDef.JoinOnFieldNames)
+		if br.hasKey {
+			idx := findJoinIndex(ps.joinIndexes, recDef.ParentRecordName, recDef.parentJoinFieldNames())
+			if parent, ok := s.keys[idx][br.key]; ok {
+				br.parent = parent
+				continue
+			}
+			msg = fmt.Sprintf("No parent record \"%s\" with %v equal to %v", recDef.ParentRecordName, recDef.parentJoinFieldNames(), strings.Split(br.key, "\x00"))
+		}
+		raw, err := jb.raw(br)
+		if err != nil {
+			return err
+		}
+		if err = ps.p.ErrorHandler(ps.recordError(recDef.Name, br.lineNum, raw, msg)); err != nil {
+			return err
+		}
+	}
+	if err := jb.countOccurs(s); err != nil {
+		return err
+	}
+	queue := make([]int, 0)
+	for i, br := range s.records {
+		name := br.recDef.Name
+		if ps.p.isSplitLevel(name) {
+			queue = append(queue, i)
+		} else if br.parent >= 0 && jb.withinSplit[name] {
+			s.records[br.parent].children = append(s.records[br.parent].children, i)
+			s.records[br.parent].size += sliceEntryOverhead
+			jb.size += sliceEntryOverhead
+		}
+	}
+
+	next := newJoinSection()
+	for name, i := range s.last {
+		if jb.withinSplit[name] {
+			continue
+		}
+		rec, err := jb.ancestor(s, i)
+		if err != nil {
+			return err
+		}
+		next.last[name] = len(next.records)
+		next.records = append(next.records, &bufferedRecord{recDef: s.records[i].recDef, rec: rec, lineNum: rec.LineNum, offset: rec.Offset, parent: -1, occurs: s.records[i].occurs})
+	}
+	//Carried Records are indexed so that key joins can find them.
+	for _, br := range next.records {
+		for _, idx := range ps.joinIndexes[br.recDef.Name] {
+			if key, ok := joinKey(br.rec, idx.fieldNames); ok {
+				if next.keys[idx] == nil {
+					next.keys[idx] = make(map[string]int)
+				}
+				next.keys[idx][key] = next.last[br.recDef.Name]
+			}
+		}
+	}
+	jb.ready, jb.queue = s, queue
+	next.carried = len(next.records)
+	jb.section = next
+	return nil
+}
+
+//countOccurs counts the Records of s beneath their parents & checks the
+//Records above the split records which are complete: those replaced by a later
+//Record of the same name (or all of them at EOF). Records within split records
+//are checked as their hierarchies are built.
+func (jb *joinBuffer) countOccurs(s *joinSection) error {
+	ps := jb.ps
+	if len(ps.occursRules) == 0 {
+		return nil
+	}
+	//Carried Records were counted in the previous section.
+	for _, br := range s.records[s.carried:] {
+		if br.parent < 0 {
+			continue
+		}
+		parent := s.records[br.parent]
+		if _, ok := ps.occursRules[parent.recDef.Name]; !ok {
+			continue
+		}
+		if parent.occurs == nil {
+			parent.occurs = make(map[string]int)
+		}
+		if parent.occurs[br.recDef.Name] == 0 {
+			parent.size += int64(mapEntryOverhead + len(br.recDef.Name))
+			jb.size += int64(mapEntryOverhead + len(br.recDef.Name))
+		}
+		parent.occurs[br.recDef.Name]++
+	}
+	for i, br := range s.records {
+		name := br.recDef.Name
+		if _, ok := ps.occursRules[name]; !ok || jb.withinSplit[name] || (!ps.eof && s.last[name] == i) {
+			continue
+		}
+		raw, err := jb.raw(br)
+		if err != nil {
+			return err
+		}
+		if err = ps.checkCounts(name, br.lineNum, raw, br.occurs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//pop returns the next queued split record with its descendants attached.
+func (jb *joinBuffer) pop() (*Record, error) {
+	s := jb.ready
+	i := jb.queue[0]
+	jb.queue = jb.queue[1:]
+	rec, err := jb.tree(s, i)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Parent, err = jb.ancestor(s, s.records[i].parent); err != nil {
+		return nil, err
+	}
+	if len(jb.queue) == 0 {
+		//Release the section (carried Records were counted in the previous section).
+		for _, br := range s.records[s.carried:] {
+			jb.size -= br.size
+		}
+		jb.ready = nil
+		if err = jb.resetSpill(); err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
+
+//tree returns the i'th Record of s with its descendants (other than split
+//records) attached.
+func (jb *joinBuffer) tree(s *joinSection, i int) (*Record, error) {
+	br := s.records[i]
+	rec, err := jb.ancestor(s, i)
+	if err != nil {
+		return nil, err
+	}
+	rec.isWithinSplit = true
+	if err = jb.ps.checkCounts(rec.Name, rec.LineNum, rec.Raw, br.occurs); err != nil {
+		return nil, err
+	}
+	rec.Children = make([]*Record, 0, len(br.children))
+	for _, c := range br.children {
+		child, err := jb.tree(s, c)
+		if err != nil {
+			return nil, err
+		}
+		rec.Children = append(rec.Children, child)
+	}
+	return rec, nil
+}
+
+//ancestor returns the i'th Record of s (or nil if i is -1) with its Parent set.
+//Records are loaded once per section so that they are shared by all of their
+//descendants.
+func (jb *joinBuffer) ancestor(s *joinSection, i int) (*Record, error) {
+	if i < 0 {
+		return nil, nil
+	}
+	if rec, ok := s.loaded[i]; ok {
+		return rec, nil
+	}
+	rec, err := jb.record(s.records[i])
+	if err != nil {
+		return nil, err
+	}
+	s.loaded[i] = rec
+	if s.records[i].parent >= 0 {
+		if rec.Parent, err = jb.ancestor(s, s.records[i].parent); err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
+
+//record returns the buffered Record, reading it back from the spill file if
+//necessary. Errors reading the Record's Fields were reported when it was first
+//read so they are ignored.
+func (jb *joinBuffer) record(br *bufferedRecord) (*Record, error) {
+	if br.rec != nil {
+		return br.rec, nil
+	}
+	raw, err := jb.raw(br)
+	if err != nil {
+		return nil, err
+	}
+	enc, _ := jb.ps.encoding(br.recDef)
+	text, err := decodeRecord(enc, raw)
+	if err != nil {
+		text = raw
+	}
+	return jb.ps.newRecord(br.recDef, enc, raw, text, br.lineNum, br.offset, func(err error) error { return nil })
+}
+
+//raw returns the original bytes of the buffered Record.
+func (jb *joinBuffer) raw(br *bufferedRecord) ([]byte, error) {
+	if br.rec != nil {
+		return br.rec.Raw, nil
+	}
+	raw := make([]byte, br.spillLen)
+	if _, err := jb.spill.ReadAt(raw, br.spillOffset); err != nil {
+		return nil, JoinBufferError{LineNum: br.lineNum, Size: jb.ps.p.JoinBufferSize, Err: err}
+	}
+	return raw, nil
+}
+
+//close removes the spill file.
+func (jb *joinBuffer) close() {
+	if jb.spill != nil {
+		jb.spill.Close()
+		os.Remove(jb.spill.Name())
+		jb.spill = nil
+	}
+}
+
+//nextBuffered reads lines until a section is complete & returns its split
+//records in turn. The last split record is returned with done set.
+func (ps *parseState) nextBuffered() (*Record, error) {
+	jb := ps.buffer
+	for len(jb.queue) == 0 {
+		if ps.eof {
+			ps.done = true
+			jb.close()
+			return nil, nil
+		}
+		if err := ps.checkContext(); err != nil {
+			return nil, err
+		}
+		if !ps.scanLine() {
+			if err := ps.scanner.Err(); err != nil {
+				return nil, SourceError{LineNum: ps.lineNum + 1, Err: err}
+			}
+			ps.eof = true
+			if err := ps.endSequence(); err != nil {
+				return nil, err
+			}
+			if err := jb.finishSection(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := ps.processLine(ps.current.data); err != nil {
+			return nil, err
+		}
+	}
+	rec, err := jb.pop()
+	if err != nil {
+		return nil, err
+	}
+	ps.sent++
+	if len(jb.queue) == 0 && ps.eof {
+		ps.done = true
+		jb.close()
+	}
+	return rec, nil
+}
@@ -0,0 +1,247 @@
+package sfr
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//bufferedJoinCfg adds a Batch record above the Header records of joinCfg &
+//joins Lines to Headers by PONumber.
+var bufferedJoinCfg = strings.Replace(strings.Replace(joinCfg, `"RecordDefinitions": [`, `"JoinMode": "Buffered",
+	"JoinSectionRecordName": "Batch",
+	"RecordDefinitions": [
+		{
+			"Name": "Batch",
+			"MatchExpression": "^B",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"},
+				{"Name": "BatchID", "TypeName": "String"}
+			]
+		},`, 1), `"ParentRecordName": "Header",`, `"ParentRecordName": "Header",
+			"JoinOnFieldNames": ["PONumber"],`, 1)
+
+func init() {
+	bufferedJoinCfg = strings.Replace(bufferedJoinCfg, `"Name": "Header",`, `"Name": "Header",
+			"ParentRecordName": "Batch",`, 1)
+}
+
+//bufferedJoinData lists all Headers, then all Lines, then all Shipments in
+//each batch.
+var bufferedJoinData = strings.Join([]string{
+	"B,1",
+	"H,PO1",
+	"H,PO2",
+	"L,PO1,1",
+	"L,PO2,1",
+	"L,PO1,2",
+	"S,PO1,2,3",
+	"S,PO2,1,7",
+	"S,PO1,1,5",
+	"B,2",
+	"H,PO3",
+	"L,PO3,1",
+	"S,PO3,1,4",
+}, "\n")
+
+func parseBuffered(t *testing.T, cfg string, data string, configure func(p *Parser)) ([]*Record, []error, error) {
+	var mu sync.Mutex
+	headers := make([]*Record, 0)
+	errs := make([]error, 0)
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(cfg)),
+		func(record *Record) error {
+			mu.Lock()
+			defer mu.Unlock()
+			headers = append(headers, record)
+			return nil
+		},
+		func(err error) error {
+			if err != nil {
+				errs = append(errs, err)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configure != nil {
+		configure(&p)
+	}
+	if err = p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	err = p.Parse(ioutil.NopCloser(strings.NewReader(data)))
+	return headers, errs, err
+}
+
+func checkBufferedHeaders(t *testing.T, headers []*Record) {
+	if len(headers) != 3 {
+		t.Fatalf("Expected 3 headers, got %d", len(headers))
+	}
+	expected := map[string][]int{"PO1": {5, 3}, "PO2": {7}, "PO3": {4}}
+	for _, header := range headers {
+		po := header.Fields[1].Value.(string)
+		quantities := expected[po]
+		if len(header.Children) != len(quantities) {
+			t.Errorf("%s: expected %d lines, got %d", po, len(quantities), len(header.Children))
+			continue
+		}
+		for i, line := range header.Children {
+			if len(line.Children) != 1 || line.Children[0].Fields[3].Value != float64(quantities[i]) {
+				t.Errorf("%s line %d: unexpected shipments %+v", po, i+1, line.Children)
+			}
+		}
+	}
+	if headers[0].Parent == nil || headers[0].Parent.Fields[1].Value != "1" || headers[2].Parent.Fields[1].Value != "2" {
+		t.Error("Headers attached to the wrong batch")
+	}
+}
+
+func TestBufferedJoin(t *testing.T) {
+	headers, errs, err := parseBuffered(t, bufferedJoinCfg, bufferedJoinData, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors %v", errs)
+	}
+	checkBufferedHeaders(t, headers)
+
+	batches := make([]*Record, 0)
+	headers, _, err = parseBuffered(t, bufferedJoinCfg, bufferedJoinData, func(p *Parser) {
+		p.RecordProcessors = map[string]RecordProcessor{"Batch": func(record *Record) error {
+			batches = append(batches, record)
+			return nil
+		}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBufferedHeaders(t, headers)
+	if len(batches) != 2 || len(batches[0].Children) != 0 || headers[0].Parent != batches[0] {
+		t.Errorf("Unexpected batches %+v", batches)
+	}
+}
+
+func TestBufferedJoinEmptyLastSection(t *testing.T) {
+	//A last section without split records sends no further (nil) Record.
+	for _, workers := range []int{1, 4} {
+		headers, errs, err := parseBuffered(t, bufferedJoinCfg, bufferedJoinData+"\nB,3", func(p *Parser) {
+			p.Workers = workers
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(errs) != 0 {
+			t.Fatalf("Unexpected errors %v", errs)
+		}
+		if len(headers) != 3 {
+			t.Fatalf("Workers %d: expected 3 headers, got %v", workers, headers)
+		}
+		for _, header := range headers {
+			if header == nil {
+				t.Errorf("Workers %d: unexpected nil Record in %v", workers, headers)
+				break
+			}
+		}
+	}
+}
+
+func TestBufferedJoinSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sfr-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	headers, errs, err := parseBuffered(t, bufferedJoinCfg, bufferedJoinData, func(p *Parser) {
+		p.JoinBufferSize = 2500
+		p.JoinSpillToDisk = true
+		p.JoinSpillDirectory = dir
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors %v", errs)
+	}
+	checkBufferedHeaders(t, headers)
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected the spill file to be removed, found %d files", len(files))
+	}
+
+	//Each Record is estimated to hold ~500 bytes with its Fields & keys.
+	_, _, err = parseBuffered(t, bufferedJoinCfg, bufferedJoinData, func(p *Parser) {
+		p.JoinBufferSize = 2500
+	})
+	var jbe JoinBufferError
+	if !errors.As(err, &jbe) || jbe.LineNum != 5 {
+		t.Errorf("Expected a JoinBufferError on line 5, got %v", err)
+	}
+}
+
+func TestBufferedJoinSpillReset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sfr-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	batches := make([]string, 20)
+	for i := range batches {
+		batches[i] = bufferedJoinData
+	}
+	var spillSize int64
+	_, errs, err := parseBuffered(t, bufferedJoinCfg, strings.Join(batches, "\n"), func(p *Parser) {
+		p.JoinBufferSize = 2500
+		p.JoinSpillToDisk = true
+		p.JoinSpillDirectory = dir
+		processor := p.RecordProcessor
+		p.RecordProcessor = func(record *Record) error {
+			files, _ := ioutil.ReadDir(dir)
+			for _, f := range files {
+				if f.Size() > spillSize {
+					spillSize = f.Size()
+				}
+			}
+			return processor(record)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors %v", errs)
+	}
+	//The spill file only holds the Records of the current sections.
+	if spillSize == 0 || spillSize > int64(len(bufferedJoinData)) {
+		t.Errorf("Expected the spill file to be reset between sections, grew to %d bytes", spillSize)
+	}
+}
+
+func TestBufferedJoinSections(t *testing.T) {
+	//Without sections, Records are joined across the whole source.
+	cfg := strings.Replace(bufferedJoinCfg, `"JoinSectionRecordName": "Batch",`, ``, 1)
+	headers, errs, err := parseBuffered(t, cfg, bufferedJoinData, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors %v", errs)
+	}
+	checkBufferedHeaders(t, headers)
+
+	//Parents are only found within the section.
+	data := bufferedJoinData + "\nL,PO1,3"
+	_, errs, err = parseBuffered(t, bufferedJoinCfg, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rpe RecordParseError
+	if len(errs) != 1 || !errors.As(errs[0], &rpe) || rpe.RecordName != "Line" || rpe.LineNum != 14 {
+		t.Errorf("Expected a RecordParseError for line 14, got %v", errs)
+	}
+}
@@ -28,6 +28,22 @@ func mustUnmarshalString(rawMap map[string]json.RawMessage, fieldName string, ta
 	return nil
 }
 
+func unmarshalStrings(rawMap map[string]json.RawMessage, fieldName string, target *[]string) error {
+	raw, ok := rawMap[fieldName]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(raw, target)
+}
+
+func unmarshalInt(rawMap map[string]json.RawMessage, fieldName string, target *int) error {
+	raw, ok := rawMap[fieldName]
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(raw, target)
+}
+
 //MissingFieldError represent a failure to find the requested field to Unmarshal
 type MissingFieldError struct {
 	Message string
@@ -36,3 +52,30 @@ type MissingFieldError struct {
 func (mfe MissingFieldError) Error() string {
 	return mfe.Message
 }
+
+//recordDefinitionsFromConfig unmarshals RecordDefinitions from JSON
+//configuration built in memory (for example by RecordDefinitionsFromStruct),
+//so that they are built exactly as if they were read from a file.
+func recordDefinitionsFromConfig(rawDefs []map[string]interface{}) ([]*RecordDefinition, error) {
+	data, err := json.Marshal(rawDefs)
+	if err != nil {
+		return nil, ConfigurationError(err)
+	}
+	recDefs := make([]*RecordDefinition, 0)
+	if err = json.Unmarshal(data, &recDefs); err != nil {
+		return nil, ConfigurationError(err)
+	}
+	return recDefs, nil
+}
+
+//parserConfig returns the JSON configuration of a Parser containing rawDefs.
+//If splitOnRecordName is empty, the first RecordDefinition is used.
+func parserConfig(splitOnRecordName string, rawDefs []map[string]interface{}) map[string]interface{} {
+	if splitOnRecordName == "" && len(rawDefs) > 0 {
+		splitOnRecordName, _ = rawDefs[0]["Name"].(string)
+	}
+	return map[string]interface{}{
+		"SplitOnRecordName": splitOnRecordName,
+		"RecordDefinitions": rawDefs,
+	}
+}
@@ -0,0 +1,94 @@
+package sfr
+
+import (
+	"fmt"
+	"sort"
+)
+
+//newOccursRules returns the RecordDefinitions with MinOccurs or MaxOccurs set
+//by ParentRecordName.
+func newOccursRules(recDefs []*RecordDefinition) map[string][]*RecordDefinition {
+	rules := make(map[string][]*RecordDefinition)
+	for _, recDef := range recDefs {
+		if recDef.ParentRecordName != "" && (recDef.MinOccurs > 0 || recDef.MaxOccurs > 0) {
+			rules[recDef.ParentRecordName] = append(rules[recDef.ParentRecordName], recDef)
+		}
+	}
+	return rules
+}
+
+//countOccurs counts a Record named name beneath parent.
+func (ps *parseState) countOccurs(parent *Record, name string) {
+	if _, ok := ps.occursRules[parent.Name]; !ok {
+		return
+	}
+	counts, ok := ps.occurs[parent]
+	if !ok {
+		counts = make(map[string]int)
+		ps.occurs[parent] = counts
+	}
+	counts[name]++
+}
+
+//checkOccurs checks the number of children of rec once its subtree is complete.
+func (ps *parseState) checkOccurs(rec *Record) error {
+	if _, ok := ps.occursRules[rec.Name]; !ok {
+		return nil
+	}
+	counts := ps.occurs[rec]
+	delete(ps.occurs, rec)
+	return ps.checkCounts(rec.Name, rec.LineNum, rec.Raw, counts)
+}
+
+//checkTreeOccurs checks a split record & its descendants before it is returned.
+func (ps *parseState) checkTreeOccurs(rec *Record) error {
+	if err := ps.checkOccurs(rec); err != nil {
+		return err
+	}
+	for _, child := range rec.Children {
+		if err := ps.checkTreeOccurs(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//checkLastOccurs checks the Records above the split records at EOF, in the
+//order they were read.
+func (ps *parseState) checkLastOccurs() error {
+	last := make([]*Record, 0)
+	for _, rec := range ps.lastRecords {
+		if !rec.isWithinSplit {
+			last = append(last, rec)
+		}
+	}
+	sort.Slice(last, func(i, j int) bool { return last[i].LineNum < last[j].LineNum })
+	for _, rec := range last {
+		if err := ps.checkOccurs(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//checkCounts passes a RecordParseError to the ErrorHandler for each child
+//RecordDefinition of the Record named name (read from raw on lineNum) whose
+//count is outside its MinOccurs & MaxOccurs.
+func (ps *parseState) checkCounts(name string, lineNum int, raw []byte, counts map[string]int) error {
+	for _, recDef := range ps.occursRules[name] {
+		n := counts[recDef.Name]
+		var msg string
+		switch {
+		case n < recDef.MinOccurs:
+			msg = fmt.Sprintf("%d \"%s\" records found, MinOccurs is %d", n, recDef.Name, recDef.MinOccurs)
+		case recDef.MaxOccurs > 0 && n > recDef.MaxOccurs:
+			msg = fmt.Sprintf("%d \"%s\" records found, MaxOccurs is %d", n, recDef.Name, recDef.MaxOccurs)
+		default:
+			continue
+		}
+		if err := ps.p.ErrorHandler(ps.recordError(name, lineNum, raw, msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package sfr
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const occursCfg = `
+{
+	"SplitOnRecordName": "POHeader",
+	"RecordDefinitions": [
+		{
+			"Name": "POBatch",
+			"MatchExpression": "^B",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"}
+			]
+		},
+		{
+			"Name": "POHeader",
+			"ParentRecordName": "POBatch",
+			"MaxOccurs": 1,
+			"MatchExpression": "^H",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"},
+				{"Name": "PONumber", "TypeName": "String"}
+			]
+		},
+		{
+			"Name": "POLine",
+			"ParentRecordName": "POHeader",
+			"MinOccurs": 1,
+			"MatchExpression": "^L",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": ","},
+			"FieldDefinitions": [
+				{"Name": "RecordType", "TypeName": "String"},
+				{"Name": "PONumber", "TypeName": "String"}
+			]
+		}
+	]
+}
+`
+
+func TestMinMaxOccurs(t *testing.T) {
+	data := strings.Join([]string{
+		"B",
+		"H,PO1",
+		"L,PO1",
+		"B",
+		"H,PO2",
+		"H,PO3",
+		"L,PO3",
+	}, "\n")
+	for _, section := range []string{"", "POBatch", "POHeader"} {
+		mode := StreamingJoinMode
+		if section != "" {
+			mode = BufferedJoinMode
+		}
+		errs := make([]error, 0)
+		p, err := NewParser(ioutil.NopCloser(strings.NewReader(occursCfg)),
+			func(record *Record) error { return nil },
+			func(err error) error {
+				if err != nil {
+					errs = append(errs, err)
+				}
+				return nil
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.JoinMode, p.JoinSectionRecordName = mode, section
+		if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+			t.Fatal(err)
+		}
+		lines := make(map[int]string)
+		for _, err := range errs {
+			var rpe RecordParseError
+			if !errors.As(err, &rpe) {
+				t.Fatalf("%s %s: Expected RecordParseErrors, got %v", mode, section, err)
+			}
+			lines[rpe.LineNum] = rpe.RecordName
+		}
+		//PO2 has no lines & the second batch has two headers.
+		if len(errs) != 2 || lines[5] != "POHeader" || lines[4] != "POBatch" {
+			t.Errorf("%s %s: Unexpected errors %v", mode, section, errs)
+		}
+	}
+}
+
+func TestValidateOccurs(t *testing.T) {
+	cfg := strings.Replace(occursCfg, `"MaxOccurs": 1,`, `"MinOccurs": 2, "MaxOccurs": 1,`, 1)
+	_, err := NewParser(ioutil.NopCloser(strings.NewReader(cfg)), nil, nil)
+	var ces ConfigurationErrors
+	if !errors.As(err, &ces) || len(ces) != 1 {
+		t.Errorf("Expected 1 ConfigurationError, got %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+package sfr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//recordSequence is a state machine (an NFA) compiled from a Parser's
+//RecordSequence. Each state either consumes a Record named name & moves to
+//out[0], or (if name is empty) moves to each of out without consuming a Record.
+type recordSequence struct {
+	states []seqState
+	start  int
+	accept int
+	//names holds the record names used in the sequence.
+	names map[string]bool
+}
+
+type seqState struct {
+	name string
+	out  []int
+}
+
+//seqFragment is a partially built piece of a recordSequence with a single
+//start & end state.
+type seqFragment struct {
+	start, end int
+}
+
+//compileRecordSequence compiles a RecordSequence such as "B (H (L S*)+)+ T".
+//Record names are separated by spaces & may be grouped with parentheses,
+//followed by "*" (zero or more), "+" (one or more) or "?" (optional) &
+//separated by "|" for alternatives.
+func compileRecordSequence(grammar string) (*recordSequence, error) {
+	c := &seqCompiler{seq: &recordSequence{names: make(map[string]bool)}, tokens: seqTokens(grammar)}
+	frag, err := c.alternation()
+	if err != nil {
+		return nil, err
+	}
+	if c.pos < len(c.tokens) {
+		return nil, fmt.Errorf("Unexpected \"%s\" in RecordSequence", c.tokens[c.pos])
+	}
+	c.seq.start = frag.start
+	c.seq.accept = frag.end
+	return c.seq, nil
+}
+
+//seqTokens divides a RecordSequence into record names & operators.
+func seqTokens(grammar string) []string {
+	tokens := make([]string, 0)
+	name := ""
+	for _, r := range grammar {
+		switch {
+		case strings.ContainsRune("()|*+?", r):
+			if name != "" {
+				tokens = append(tokens, name)
+				name = ""
+			}
+			tokens = append(tokens, string(r))
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if name != "" {
+				tokens = append(tokens, name)
+				name = ""
+			}
+		default:
+			name += string(r)
+		}
+	}
+	if name != "" {
+		tokens = append(tokens, name)
+	}
+	return tokens
+}
+
+//seqCompiler builds a recordSequence by recursive descent.
+type seqCompiler struct {
+	seq    *recordSequence
+	tokens []string
+	pos    int
+}
+
+func (c *seqCompiler) state(name string, out ...int) int {
+	c.seq.states = append(c.seq.states, seqState{name: name, out: out})
+	return len(c.seq.states) - 1
+}
+
+func (c *seqCompiler) link(from int, to int) {
+	c.seq.states[from].out = append(c.seq.states[from].out, to)
+}
+
+func (c *seqCompiler) peek() string {
+	if c.pos < len(c.tokens) {
+		return c.tokens[c.pos]
+	}
+	return ""
+}
+
+//alternation := sequence ("|" sequence)*
+func (c *seqCompiler) alternation() (seqFragment, error) {
+	frag, err := c.sequence()
+	if err != nil {
+		return frag, err
+	}
+	if c.peek() != "|" {
+		return frag, nil
+	}
+	alt := seqFragment{start: c.state("", frag.start), end: c.state("")}
+	c.link(frag.end, alt.end)
+	for c.peek() == "|" {
+		c.pos++
+		frag, err = c.sequence()
+		if err != nil {
+			return frag, err
+		}
+		c.link(alt.start, frag.start)
+		c.link(frag.end, alt.end)
+	}
+	return alt, nil
+}
+
+//sequence := repetition*
+func (c *seqCompiler) sequence() (seqFragment, error) {
+	start := c.state("")
+	seq := seqFragment{start: start, end: start}
+	for tok := c.peek(); tok != "" && tok != "|" && tok != ")"; tok = c.peek() {
+		frag, err := c.repetition()
+		if err != nil {
+			return frag, err
+		}
+		c.link(seq.end, frag.start)
+		seq.end = frag.end
+	}
+	return seq, nil
+}
+
+//repetition := (name | "(" alternation ")") ("*" | "+" | "?")*
+func (c *seqCompiler) repetition() (seqFragment, error) {
+	var frag seqFragment
+	switch tok := c.peek(); tok {
+	case "(":
+		c.pos++
+		var err error
+		if frag, err = c.alternation(); err != nil {
+			return frag, err
+		}
+		if c.peek() != ")" {
+			return frag, fmt.Errorf("Missing \")\" in RecordSequence")
+		}
+		c.pos++
+	case "*", "+", "?":
+		return frag, fmt.Errorf("Unexpected \"%s\" in RecordSequence", tok)
+	default:
+		c.pos++
+		c.seq.names[tok] = true
+		frag.end = c.state("")
+		frag.start = c.state(tok, frag.end)
+	}
+	for {
+		switch c.peek() {
+		case "*":
+			//Loop back to the start, allowing it to be skipped.
+			rep := seqFragment{start: c.state("", frag.start), end: c.state("")}
+			c.link(rep.start, rep.end)
+			c.link(frag.end, frag.start)
+			c.link(frag.end, rep.end)
+			frag = rep
+		case "+":
+			rep := seqFragment{start: frag.start, end: c.state("")}
+			c.link(frag.end, frag.start)
+			c.link(frag.end, rep.end)
+			frag = rep
+		case "?":
+			opt := seqFragment{start: c.state("", frag.start), end: c.state("")}
+			c.link(opt.start, opt.end)
+			c.link(frag.end, opt.end)
+			frag = opt
+		default:
+			return frag, nil
+		}
+		c.pos++
+	}
+}
+
+//closure adds the states reachable from states without consuming a Record.
+func (seq *recordSequence) closure(states []int) []int {
+	seen := make(map[int]bool)
+	result := make([]int, 0)
+	var visit func(i int)
+	visit = func(i int) {
+		if seen[i] {
+			return
+		}
+		seen[i] = true
+		result = append(result, i)
+		if seq.states[i].name == "" {
+			for _, o := range seq.states[i].out {
+				visit(o)
+			}
+		}
+	}
+	for _, i := range states {
+		visit(i)
+	}
+	return result
+}
+
+//step returns the states reached by consuming a Record named name.
+func (seq *recordSequence) step(states []int, name string) []int {
+	next := make([]int, 0)
+	for _, i := range states {
+		if seq.states[i].name == name {
+			next = append(next, seq.states[i].out...)
+		}
+	}
+	return seq.closure(next)
+}
+
+//expected returns the record names which may follow states.
+func (seq *recordSequence) expected(states []int) []string {
+	names := make(map[string]bool)
+	for _, i := range states {
+		if name := seq.states[i].name; name != "" {
+			names[name] = true
+		}
+	}
+	expected := make([]string, 0, len(names))
+	for name := range names {
+		expected = append(expected, name)
+	}
+	sort.Strings(expected)
+	return expected
+}
+
+//accepts returns true if the sequence may end in states.
+func (seq *recordSequence) accepts(states []int) bool {
+	for _, i := range states {
+		if i == seq.accept {
+			return true
+		}
+	}
+	return false
+}
+
+//compiledSequence returns the recordSequence of the Parser's RecordSequence
+//(nil if it is not set), reusing the one compiled by Validate unless
+//RecordSequence has changed since.
+func (p *Parser) compiledSequence() (*recordSequence, error) {
+	if p.RecordSequence == "" {
+		return nil, nil
+	}
+	if p.sequence != nil && p.sequenceGrammar == p.RecordSequence {
+		return p.sequence, nil
+	}
+	seq, err := compileRecordSequence(p.RecordSequence)
+	if err != nil {
+		return nil, ConfigurationError(err)
+	}
+	return seq, nil
+}
+
+//sequenceChecker follows the Records read through a recordSequence.
+type sequenceChecker struct {
+	seq    *recordSequence
+	states []int
+	//failed is set once an out of sequence Record has been reported.
+	failed bool
+}
+
+func newSequenceChecker(seq *recordSequence) *sequenceChecker {
+	if seq == nil {
+		return nil
+	}
+	return &sequenceChecker{seq: seq, states: seq.closure([]int{seq.start})}
+}
+
+//checkSequence moves the sequence on by rec, reporting the first Record which
+//is out of sequence to the ErrorHandler. Records whose names aren't used in the
+//RecordSequence are ignored.
+func (ps *parseState) checkSequence(rec *Record) error {
+	sc := ps.sequence
+	if sc == nil || sc.failed {
+		return nil
+	}
+	if !sc.seq.names[rec.Name] {
+		return nil
+	}
+	next := sc.seq.step(sc.states, rec.Name)
+	if len(next) == 0 {
+		sc.failed = true
+		return ps.p.ErrorHandler(RecordSequenceError{
+			RecordName: rec.Name,
+			LineNum:    rec.LineNum,
+			Expected:   sc.seq.expected(sc.states),
+			Snippet:    snippet(rec.Raw),
+		})
+	}
+	sc.states = next
+	return nil
+}
+
+//endSequence reports a source which ends part way through the RecordSequence.
+func (ps *parseState) endSequence() error {
+	sc := ps.sequence
+	if sc == nil || sc.failed || sc.seq.accepts(sc.states) {
+		return nil
+	}
+	sc.failed = true
+	return ps.p.ErrorHandler(RecordSequenceError{
+		LineNum:  ps.lineNum + 1,
+		Expected: sc.seq.expected(sc.states),
+	})
+}
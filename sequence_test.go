@@ -0,0 +1,121 @@
+package sfr
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordSequence(t *testing.T) {
+	seq, err := compileRecordSequence("B (H (L S*)+)+ T?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		names    string
+		ok       bool
+		expected []string
+	}{
+		{"B H L S S L H L T", true, nil},
+		{"B H L", true, nil},
+		{"B H S", false, []string{"L"}},
+		{"B H L T H", false, nil},
+		{"B H", false, []string{"L"}},
+		{"H", false, []string{"B"}},
+	}
+	for _, test := range tests {
+		states := seq.closure([]int{seq.start})
+		ok := true
+		for _, name := range strings.Fields(test.names) {
+			next := seq.step(states, name)
+			if len(next) == 0 {
+				ok = false
+				break
+			}
+			states = next
+		}
+		if ok = ok && seq.accepts(states); ok != test.ok {
+			t.Errorf("%s: expected %v", test.names, test.ok)
+		}
+		if test.expected != nil && !reflect.DeepEqual(seq.expected(states), test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.names, test.expected, seq.expected(states))
+		}
+	}
+
+	for _, grammar := range []string{"(B", "B)", "* B", "B | (H"} {
+		if _, err := compileRecordSequence(grammar); err == nil {
+			t.Errorf("Expected an error compiling %q", grammar)
+		}
+	}
+}
+
+func TestParseRecordSequence(t *testing.T) {
+	data := strings.Join([]string{
+		"H,PO1",
+		"S,PO1,1,5",
+		"L,PO1,1",
+		"S,PO1,1,2",
+	}, "\n")
+	errs := make([]error, 0)
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(joinCfg)), nil,
+		func(err error) error {
+			if err != nil {
+				errs = append(errs, err)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.RecordSequence = "(Header (Line Shipment*)+)+"
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	var rse RecordSequenceError
+	if len(errs) == 0 || !errors.As(errs[0], &rse) || rse.LineNum != 2 || rse.RecordName != "Shipment" || !reflect.DeepEqual(rse.Expected, []string{"Line"}) {
+		t.Errorf("Expected a RecordSequenceError for line 2, got %v", errs)
+	}
+
+	//A source ending part way through the sequence.
+	errs = errs[:0]
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader("H,PO1"))); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || !errors.As(errs[0], &rse) || rse.RecordName != "" || rse.LineNum != 2 {
+		t.Errorf("Expected a RecordSequenceError at the end of the source, got %v", errs)
+	}
+
+	p.RecordSequence = "Header Trailer"
+	var ces ConfigurationErrors
+	if err = p.Validate(); !errors.As(err, &ces) || len(ces) != 1 {
+		t.Errorf("Expected 1 ConfigurationError, got %v", err)
+	}
+}
+
+func TestRecordSequenceCompiledOnce(t *testing.T) {
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(joinCfg)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.RecordSequence = "(Header (Line Shipment*)+)+"
+	if err = p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := p.newParseState(context.Background(), strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.sequence == nil || ps.sequence.seq != p.sequence {
+		t.Error("Expected Parse to reuse the RecordSequence compiled by Validate")
+	}
+
+	//A RecordSequence changed since Validate is compiled before any line is read.
+	p.RecordSequence = "(Header"
+	err = p.Parse(ioutil.NopCloser(strings.NewReader("X,unmatched")))
+	if _, ok := err.(ConfigurationError); !ok {
+		t.Errorf("Expected a ConfigurationError, got %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package sfr
+
+import (
+	"context"
+	"sort"
+)
+
+//recordAncestors returns the names of the ancestors of each RecordDefinition,
+//nearest first.
+func recordAncestors(recDefs []*RecordDefinition) map[string][]string {
+	byName := make(map[string]*RecordDefinition)
+	for _, recDef := range recDefs {
+		byName[recDef.Name] = recDef
+	}
+	ancestors := make(map[string][]string)
+	for _, recDef := range recDefs {
+		names := make([]string, 0)
+		//Limit the walk in case the parents form a cycle.
+		for parent, ok := byName[recDef.ParentRecordName]; ok && len(names) < len(byName); parent, ok = byName[parent.ParentRecordName] {
+			names = append(names, parent.Name)
+		}
+		ancestors[recDef.Name] = names
+	}
+	return ancestors
+}
+
+//isSplitLevel returns true if Records named name are sent to a RecordProcessor
+//(they are named SplitOnRecordName or have an entry in RecordProcessors).
+func (p *Parser) isSplitLevel(name string) bool {
+	if name == p.SplitOnRecordName {
+		return true
+	}
+	_, ok := p.RecordProcessors[name]
+	return ok
+}
+
+//routeProcessor returns a ContextRecordProcessor which passes each Record to
+//its entry in RecordProcessors, or to processor if there is none.
+func (p *Parser) routeProcessor(processor ContextRecordProcessor) ContextRecordProcessor {
+	if len(p.RecordProcessors) == 0 {
+		return processor
+	}
+	return func(ctx context.Context, record *Record) error {
+		if record != nil {
+			if rp, ok := p.RecordProcessors[record.Name]; ok {
+				return rp(record)
+			}
+		}
+		return processor(ctx, record)
+	}
+}
+
+//hasAncestor returns true if ancestor is above Records named name in the hierarchy.
+func (ps *parseState) hasAncestor(name string, ancestor string) bool {
+	for _, a := range ps.ancestors[name] {
+		if a == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+//closeSplits completes the open split records named name & those beneath
+//them in the hierarchy (or every open split record if name is empty), adding
+//them to pending deepest first.
+func (ps *parseState) closeSplits(name string) {
+	closing := make([]*Record, 0)
+	for n, rec := range ps.splitRecs {
+		if name == "" || n == name || ps.hasAncestor(n, name) {
+			closing = append(closing, rec)
+			delete(ps.splitRecs, n)
+		}
+	}
+	sort.Slice(closing, func(i, j int) bool {
+		di, dj := len(ps.ancestors[closing[i].Name]), len(ps.ancestors[closing[j].Name])
+		if di != dj {
+			return di > dj
+		}
+		return closing[i].LineNum < closing[j].LineNum
+	})
+	for _, rec := range closing {
+		ps.pending = append(ps.pending, pendingItem{record: rec})
+	}
+}
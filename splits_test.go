@@ -0,0 +1,71 @@
+package sfr
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRecordProcessors(t *testing.T) {
+	config, err := os.Open("testfiles/DelimitedPurchaseOrder/po.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make([]string, 0)
+	headers := make([]*Record, 0)
+	batches := make([]*Record, 0)
+	p, err := NewParser(config, func(record *Record) error {
+		headers = append(headers, record)
+		sent = append(sent, record.Name)
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.RecordProcessors = map[string]RecordProcessor{
+		"POBatch": func(record *Record) error {
+			batches = append(batches, record)
+			sent = append(sent, record.Name)
+			return nil
+		},
+	}
+	if err = p.ParseFile("testfiles", "DelimitedPurchaseOrder", "po.dat"); err != nil {
+		t.Fatal(err)
+	}
+	//Each POBatch is sent once its last POHeader has been sent.
+	expected := []string{"POHeader", "POHeader", "POBatch", "POHeader", "POBatch"}
+	if len(sent) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, sent)
+	}
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, sent)
+		}
+	}
+	if len(batches[0].Children) != 0 {
+		t.Errorf("POHeaders should not be added to the Children of a POBatch, got %d", len(batches[0].Children))
+	}
+	if headers[1].Parent != batches[0] || headers[2].Parent != batches[1] {
+		t.Error("POHeaders should reference their POBatch as Parent")
+	}
+	if len(headers[0].Children) != 2 || len(headers[0].Children[0].Children) != 2 {
+		t.Errorf("Unexpected POHeader hierarchy %+v", headers[0])
+	}
+
+	//The iterator returns Records of every level in the same order.
+	config, _ = os.Open("testfiles/DelimitedPurchaseOrder/po.json")
+	p, _ = NewParser(config, nil, nil)
+	p.RecordProcessors = map[string]RecordProcessor{"POBatch": nil}
+	source, err := os.Open("testfiles/DelimitedPurchaseOrder/po.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := p.Records(source)
+	defer it.Close()
+	names := make([]string, 0)
+	for it.Next() {
+		names = append(names, it.Record().Name)
+	}
+	if it.Err() != nil || len(names) != len(expected) || names[2] != "POBatch" {
+		t.Errorf("Expected %v, got %v (%v)", expected, names, it.Err())
+	}
+}
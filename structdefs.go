@@ -0,0 +1,226 @@
+package sfr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//RecordDefinitionsFromStruct builds RecordDefinitions by reflecting over the
+//tagged struct type of root (a struct or pointer to a struct) and any child
+//record types nested beneath it.
+//
+//Record information is declared with tags on a blank field:
+//	_ struct{} `sfr:"record=POHeader" sfrmatch:"^H" sfrdelimiter:","`
+//record= sets the record name (defaulting to the struct type name), sfrmatch
+//sets the MatchExpression & sfrdelimiter selects the Delimited RecordReader.
+//Records without a delimiter use the FixedWidth RecordReader.
+//
+//Fields are declared with the same tags used by Record.Decode plus:
+//	sfrpos:"0,10"          - fixed width start & end coordinates.
+//	sfrtype:"Number"       - the FieldType name. Defaults to Date for time.Time,
+//	                         Number for numeric types & String for everything else.
+//	sfrformat:"2006-01-02" - the Format of a Date FieldType.
+//	sfrdecimals:"2"        - the ConvertToDecimalPlaces of a Number FieldType.
+//
+//Fields tagged with sfr:"child=Name" (slices, structs or struct pointers)
+//declare child records whose ParentRecordName is the enclosing record.
+func RecordDefinitionsFromStruct(root interface{}) ([]*RecordDefinition, error) {
+	rawDefs, err := recordConfigsFromStruct(root)
+	if err != nil {
+		return nil, err
+	}
+	return recordDefinitionsFromConfig(rawDefs)
+}
+
+//NewParserFromStruct returns a Parser whose RecordDefinitions are built from
+//root by RecordDefinitionsFromStruct. If splitOnRecordName is empty, the
+//Parser splits on the record defined by root.
+func NewParserFromStruct(root interface{}, splitOnRecordName string, processor RecordProcessor, handler ErrorHandler) (parser Parser, err error) {
+	rawDefs, err := recordConfigsFromStruct(root)
+	if err != nil {
+		return
+	}
+	config, err := json.Marshal(parserConfig(splitOnRecordName, rawDefs))
+	if err != nil {
+		return parser, ConfigurationError(err)
+	}
+	return NewParser(ioutil.NopCloser(bytes.NewReader(config)), processor, handler)
+}
+
+//recordConfigsFromStruct returns the JSON configuration of each
+//RecordDefinition described by root, root first.
+func recordConfigsFromStruct(root interface{}) ([]map[string]interface{}, error) {
+	t := reflect.TypeOf(root)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, ConfigurationError(fmt.Errorf("RecordDefinitions can only be built from a struct, got %T", root))
+	}
+	b := structDefBuilder{seen: make(map[string]bool)}
+	if err := b.add(t, "", ""); err != nil {
+		return nil, err
+	}
+	return b.defs, nil
+}
+
+//structDefBuilder accumulates RecordDefinition configurations.
+type structDefBuilder struct {
+	defs []map[string]interface{}
+	seen map[string]bool
+}
+
+//add appends the configuration for struct type t & its children.
+//name overrides the record name declared on t (used for child= tags).
+func (b *structDefBuilder) add(t reflect.Type, name string, parentName string) error {
+	recName := t.Name()
+	matchExpression := ""
+	delimiter := ""
+	for i := 0; i < t.NumField(); i++ {
+		structFld := t.Field(i)
+		tag := structFld.Tag.Get("sfr")
+		if structFld.Name != "_" || !strings.HasPrefix(tag, "record=") {
+			continue
+		}
+		recName = strings.TrimPrefix(tag, "record=")
+		matchExpression = structFld.Tag.Get("sfrmatch")
+		delimiter = structFld.Tag.Get("sfrdelimiter")
+	}
+	if name != "" {
+		recName = name
+	}
+	if recName == "" {
+		return ConfigurationError(fmt.Errorf("No record name declared for anonymous struct %s", t))
+	}
+	if b.seen[recName] {
+		return ConfigurationError(fmt.Errorf("Record \"%s\" is declared more than once", recName))
+	}
+	b.seen[recName] = true
+
+	def := map[string]interface{}{
+		"Name":            recName,
+		"MatchExpression": matchExpression,
+	}
+	if parentName != "" {
+		def["ParentRecordName"] = parentName
+	}
+	b.defs = append(b.defs, def)
+
+	fldDefs := make([]map[string]interface{}, 0)
+	coords := make([]FixedWidthFieldCoordinate, 0)
+	type child struct {
+		name string
+		t    reflect.Type
+	}
+	children := make([]child, 0)
+	for i := 0; i < t.NumField(); i++ {
+		structFld := t.Field(i)
+		tag, ok := structFld.Tag.Lookup("sfr")
+		if !ok || tag == "-" || structFld.PkgPath != "" {
+			continue
+		}
+		if strings.HasPrefix(tag, "child=") {
+			childType := structFld.Type
+			for childType.Kind() == reflect.Ptr || childType.Kind() == reflect.Slice {
+				childType = childType.Elem()
+			}
+			if childType.Kind() != reflect.Struct {
+				return ConfigurationError(fmt.Errorf("Record \"%s\" field %s: child records must be structs", recName, structFld.Name))
+			}
+			children = append(children, child{name: strings.TrimPrefix(tag, "child="), t: childType})
+			continue
+		}
+		fldDef, err := fieldConfigFromStruct(structFld, tag)
+		if err != nil {
+			return ConfigurationError(fmt.Errorf("Record \"%s\" field %s: %s", recName, structFld.Name, err))
+		}
+		fldDefs = append(fldDefs, fldDef)
+		if delimiter == "" {
+			coord, err := coordinateFromStruct(structFld)
+			if err != nil {
+				return ConfigurationError(fmt.Errorf("Record \"%s\" field %s: %s", recName, structFld.Name, err))
+			}
+			coords = append(coords, coord)
+		}
+	}
+	def["FieldDefinitions"] = fldDefs
+	if delimiter == "" {
+		def["ReaderName"] = fixedWidthRecordReaderName
+		def["RecordReader"] = FixedWidthRecordReader{Coordinates: coords}
+	} else {
+		def["ReaderName"] = delimitedRecordReaderName
+		def["RecordReader"] = DelimitedRecordReader{Delimiter: delimiter}
+	}
+
+	for _, c := range children {
+		if err := b.add(c.t, c.name, recName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//fieldConfigFromStruct returns the JSON configuration of a FieldDefinition.
+func fieldConfigFromStruct(structFld reflect.StructField, name string) (map[string]interface{}, error) {
+	typeName := structFld.Tag.Get("sfrtype")
+	if typeName == "" {
+		typeName = defaultTypeName(structFld.Type)
+	}
+	fieldType := make(map[string]interface{})
+	if format, ok := structFld.Tag.Lookup("sfrformat"); ok {
+		fieldType["Format"] = format
+	}
+	if decimals, ok := structFld.Tag.Lookup("sfrdecimals"); ok {
+		places, err := strconv.Atoi(decimals)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid sfrdecimals \"%s\"", decimals)
+		}
+		fieldType["ConvertToDecimalPlaces"] = places
+	}
+	return map[string]interface{}{
+		"Name":      name,
+		"TypeName":  typeName,
+		"FieldType": fieldType,
+	}, nil
+}
+
+//defaultTypeName returns the FieldType name for a Go type.
+func defaultTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return "Date"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "Number"
+	}
+	return "String"
+}
+
+//coordinateFromStruct parses the sfrpos tag of a fixed width field.
+func coordinateFromStruct(structFld reflect.StructField) (coord FixedWidthFieldCoordinate, err error) {
+	pos, ok := structFld.Tag.Lookup("sfrpos")
+	if !ok {
+		return coord, fmt.Errorf("sfrpos is required for fixed width records")
+	}
+	parts := strings.Split(pos, ",")
+	if len(parts) != 2 {
+		return coord, fmt.Errorf("Invalid sfrpos \"%s\"", pos)
+	}
+	if coord.Start, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return coord, fmt.Errorf("Invalid sfrpos \"%s\"", pos)
+	}
+	if coord.End, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+		return coord, fmt.Errorf("Invalid sfrpos \"%s\"", pos)
+	}
+	return coord, nil
+}
@@ -0,0 +1,103 @@
+package sfr
+
+import (
+	"testing"
+	"time"
+)
+
+type structPOShipment struct {
+	_            struct{}  `sfr:"record=POShipment" sfrmatch:"^S" sfrdelimiter:","`
+	RecordType   string    `sfr:"RecordType"`
+	Quantity     int       `sfr:"Quantity"`
+	DeliveryDate time.Time `sfr:"DeliveryDate" sfrformat:"2006-01-02"`
+}
+
+type structPOLine struct {
+	_             struct{}            `sfr:"record=POLine" sfrmatch:"^L" sfrdelimiter:","`
+	RecordType    string              `sfr:"RecordType"`
+	LineNumber    string              `sfr:"LineNumber"`
+	PartNumber    string              `sfr:"PartNumber"`
+	UnitOfMeasure string              `sfr:"unitOfMeasure"`
+	UnitPrice     float64             `sfr:"UnitPrice" sfrdecimals:"2"`
+	Shipments     []*structPOShipment `sfr:"child=POShipment"`
+}
+
+type structPOHeader struct {
+	_            struct{}       `sfr:"record=POHeader" sfrmatch:"^H" sfrdelimiter:","`
+	RecordType   string         `sfr:"RecordType"`
+	PONumber     string         `sfr:"PONumber"`
+	VendorCode   string         `sfr:"VendorCode"`
+	VendorName   string         `sfr:"VendorName"`
+	PaymentTerms string         `sfr:"PaymentTerms"`
+	Lines        []structPOLine `sfr:"child=POLine"`
+}
+
+type structPOBatch struct {
+	_          struct{}         `sfr:"record=POBatch" sfrmatch:"^B" sfrdelimiter:","`
+	RecordType string           `sfr:"RecordType"`
+	BatchID    string           `sfr:"BatchID"`
+	Headers    []structPOHeader `sfr:"child=POHeader"`
+}
+
+func TestNewParserFromStruct(t *testing.T) {
+	headers := make([]structPOHeader, 0)
+	p, err := NewParserFromStruct(
+		structPOBatch{},
+		"POHeader",
+		func(record *Record) error {
+			var header structPOHeader
+			if err := record.Decode(&header); err != nil {
+				return err
+			}
+			headers = append(headers, header)
+			return nil
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.RecordDefinitions) != 4 || p.RecordDefinitions[3].ParentRecordName != "POLine" {
+		t.Fatalf("Unexpected RecordDefinitions %+v", p.RecordDefinitions)
+	}
+	if err = p.ParseFile("testfiles", "DelimitedPurchaseOrder", "po.dat"); err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 3 {
+		t.Fatalf("Expected 3 headers, got %d", len(headers))
+	}
+	line := headers[2].Lines[1]
+	if line.PartNumber != "ACMA-FFF-A" || line.UnitPrice != 99.99 || line.Shipments[0].Quantity != 3 {
+		t.Errorf("Unexpected line %+v", line)
+	}
+}
+
+func TestRecordDefinitionsFromStructFixedWidth(t *testing.T) {
+	type dist struct {
+		_        struct{} `sfr:"record=InvoiceLineDist" sfrmatch:"^033"`
+		RecordID string   `sfr:"RecordID" sfrpos:"0,3"`
+		Amount   float64  `sfr:"Amount" sfrpos:"3,10" sfrdecimals:"2"`
+	}
+	recDefs, err := RecordDefinitionsFromStruct(&dist{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := recDefs[0].RecordReader.Read([]byte("0330012345"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	amt, err := recDefs[0].FieldDefinitions[1].FieldType.GetValue(vals[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals[0] != "033" || amt != 123.45 {
+		t.Errorf("Unexpected values %v, %v", vals, amt)
+	}
+
+	type missingPos struct {
+		RecordID string `sfr:"RecordID"`
+	}
+	if _, err = RecordDefinitionsFromStruct(missingPos{}); err == nil {
+		t.Error("Expected an error for a fixed width field without sfrpos")
+	}
+}
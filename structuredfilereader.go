@@ -2,12 +2,15 @@ package sfr
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sync/atomic"
 )
 
 //logger to write logs to (defaults to Dev/Null).
@@ -40,9 +43,93 @@ type Parser struct {
 	SplitOnRecordName string
 	RecordProcessor   RecordProcessor
 	ErrorHandler      ErrorHandler
+	//RecordProcessors sends Records with other names (or SplitOnRecordName) to
+	//their own RecordProcessor so that several levels of the hierarchy can be
+	//processed independently. Each is sent when the next Record of that name
+	//(or of a name above it in the hierarchy) is found, or at EOF. Records
+	//sent to a RecordProcessor are not added to the Children of their parent.
+	RecordProcessors map[string]RecordProcessor `json:"-"`
+	//AncestorComplete, if set, is called with a summary of each Record above
+	//SplitOnRecordName once it is complete: when the next Record of its name
+	//(or of a name above it) is found, or at EOF. The split records beneath it
+	//are completed first (with Workers, once the RecordProcessor has returned
+	//for each of them). An error returned by AncestorComplete stops the parse &
+	//is returned as a ProcessorError. It is not supported by Buffered joins.
+	AncestorComplete AncestorCompleteFunc `json:"-"`
+	//UnmatchedLinePolicy sets what happens to lines which match no
+	//RecordDefinition: "Skip" (the default) skips them, "Error" passes an
+	//UnmatchedLineError to the ErrorHandler & "Handler" passes them to the
+	//UnmatchedLineHandler (which must then be set). In every case they are
+	//counted (see UnmatchedLineCount).
+	UnmatchedLinePolicy  string
+	UnmatchedLineHandler UnmatchedLineHandler `json:"-"`
+	//RecordSequence, if set, is a grammar of the order in which Records may
+	//appear, such as "POBatch (POHeader (POLine POShipment*)+)+ POTrailer",
+	//using parentheses, "*", "+", "?" & "|". Records not named in it are
+	//ignored. The first Record out of sequence (or an early end of the source)
+	//is passed to the ErrorHandler as a RecordSequenceError.
+	RecordSequence string
+	//ContextRecordProcessor, if set, is called by ParseContext & ParseFileContext
+	//instead of RecordProcessor so that it can honour the context.
+	ContextRecordProcessor ContextRecordProcessor
+	//Workers sets the number of goroutines calling the RecordProcessor.
+	//If it is greater than 1, split Records are queued (up to WorkerQueueSize,
+	//which defaults to Workers) & processed concurrently, in no particular order.
+	//The first processor error stops the scan & is returned as a ProcessorError
+	//(it is not passed to the ErrorHandler). By default it is the error of the
+	//earliest failing split Record in the source; UnorderedDelivery only changes
+	//this to the first error to occur. Either way, later Records may already
+	//have been processed.
+	Workers           int
+	WorkerQueueSize   int
+	UnorderedDelivery bool
+	//RecordLength & RecordTerminator configure how the source is divided into
+	//records (see Framing). By default each line (ending in "\n" or "\r\n") is
+	//a record.
+	RecordLength     int
+	RecordTerminator string
+	//MaxRecordLines is the most lines a multi-line record (such as a Delimited
+	//record with line breaks in quoted values) may span. It defaults to 100 &
+	//-1 removes the limit. Records longer than this (or than MaxRecordSize, or
+	//which are still incomplete at the end of the source) are reported as a
+	//RecordParseError & the lines after the first are read as new records.
+	MaxRecordLines int
+	//MaxRecordSize is the largest record (including its terminator) which can
+	//be read. It defaults to bufio.MaxScanTokenSize (64KB). Set it to -1 to read
+	//records of any size, growing the record as it is read in chunks.
+	MaxRecordSize int
+	//Encoding is the name of the character Encoding (see EncodingRegistry) of
+	//the source. It defaults to UTF-8 & may be overridden by RecordDefinitions.
+	Encoding string
+	//JoinIndexSize limits the number of Records above SplitOnRecordName which
+	//are kept for each parent of a key join (see JoinOnFieldNames). They are
+	//dropped when the next Record above them in the hierarchy is read, or, once
+	//there are more than JoinIndexSize (default 10000, -1 for no limit), oldest
+	//first.
+	JoinIndexSize int
+	//JoinMode is "Streaming" (the default) to attach each Record to its parent
+	//as it is read, or "Buffered" to buffer the Records of each section & attach
+	//them once the section is complete, so that children need not follow their
+	//parents. Sections end when a record named JoinSectionRecordName is read (or
+	//at the end of the source). JoinBufferSize limits the estimated bytes of
+	//memory held for buffered Records (their Raw data, Fields & join keys),
+	//beyond which Records are spilled to a temporary file in
+	//JoinSpillDirectory (the default temporary directory if empty) when
+	//JoinSpillToDisk is set, otherwise a JoinBufferError is returned.
+	JoinMode              string
+	JoinSectionRecordName string
+	JoinBufferSize        int64
+	JoinSpillToDisk       bool
+	JoinSpillDirectory    string
+	//sequence is the RecordSequence compiled by Validate (from sequenceGrammar).
+	sequence        *recordSequence
+	sequenceGrammar string
+	//unmatchedLines holds the UnmatchedLineCount of the last Parse to finish.
+	unmatchedLines atomic.Value
 }
 
 //NewParser returns a Parser using the JSON configuration read from r.
+//The configuration is checked using Validate.
 func NewParser(config io.ReadCloser, processor RecordProcessor, handler ErrorHandler) (parser Parser, err error) {
 	defer config.Close()
 	err = ConfigurationError(json.NewDecoder(config).Decode(&parser))
@@ -59,6 +146,7 @@ func NewParser(config io.ReadCloser, processor RecordProcessor, handler ErrorHan
 	} else {
 		parser.ErrorHandler = handler
 	}
+	err = parser.Validate()
 	return
 }
 
@@ -74,6 +162,16 @@ func (p *Parser) ParseFile(elem ...string) error {
 	return p.Parse(file)
 }
 
+//ParseFileContext opens the provided file & calls ParseContext to process the file.
+func (p *Parser) ParseFileContext(ctx context.Context, elem ...string) error {
+	//Open the file
+	file, err := os.Open(filepath.Join(elem...))
+	if err != nil {
+		return err
+	}
+	return p.ParseContext(ctx, file)
+}
+
 //Parse parses the requested file calling the Parsers RecordProcessor  for each
 //occurence of a record whose name matches SplitOnRecordName. It also calls the
 //Parsers ErrorHandler if an error is encountered.
@@ -88,109 +186,608 @@ func (p *Parser) ParseFile(elem ...string) error {
 //FieldName which caused the error. This means that if a custom ErrorHandler is
 //provided to the Parser, it can ignore errors on certain Records / Fields.
 func (p *Parser) Parse(source io.ReadCloser) error {
+	return p.ParseContext(context.Background(), source)
+}
+
+//ParseContext behaves like Parse but stops when ctx is done. The context is
+//checked between lines & before each call to the RecordProcessor. If it is
+//done, a ContextError containing ctx.Err() & the line number reached is returned.
+//If the Parser has a ContextRecordProcessor it is called with ctx in place of
+//the RecordProcessor.
+func (p *Parser) ParseContext(ctx context.Context, source io.ReadCloser) error {
 	defer source.Close()
-	scanner := bufio.NewScanner(source)
-	scanner.Split(bufio.ScanLines)
-	//splitRec holds the current record with the name SplitOnRecordName.
-	//When this changes (when we are about to write a new Record to this variable)
-	//we need to call the callback with this value first.
-	var splitRec *Record
+	if err := p.checkProcessors(); err != nil {
+		return err
+	}
+	ps, err := p.newParseState(ctx, source)
+	if err != nil {
+		return err
+	}
+	defer ps.close()
+	defer func() {
+		p.unmatchedLines.Store(ps.unmatched)
+	}()
+	processor := p.ContextRecordProcessor
+	if processor == nil {
+		processor = func(ctx context.Context, record *Record) error {
+			return p.RecordProcessor(record)
+		}
+	}
+	processor = p.routeProcessor(processor)
+	if p.Workers > 1 {
+		return p.parseConcurrent(ctx, ps, processor)
+	}
+	for {
+		splitRec, err := ps.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = ps.checkContext(); err != nil {
+			return err
+		}
+		if ps.done {
+			if splitRec == nil && ps.sent > 0 {
+				//The last split record was sent before its ancestors completed.
+				return nil
+			}
+			//Finally, send the last split record we have.
+			return p.ErrorHandler(processor(ctx, splitRec))
+		}
+		if err = processor(ctx, splitRec); err != nil {
+			return err
+		}
+	}
+}
+
+//parseState holds the state required to build the Record hierarchy as lines
+//are read from the source. It is shared by Parse & RecordIterator.
+type parseState struct {
+	p       *Parser
+	ctx     context.Context
+	scanner recordScanner
+	//splitRecs holds the current record for SplitOnRecordName & each name in
+	//RecordProcessors. When one changes (when we are about to write a new Record
+	//to this map) the previous Record (& any open split records beneath it) are
+	//complete & added to pending to be returned.
+	splitRecs map[string]*Record
+	pending   []pendingItem
+	//ancestorSummaries holds the open Record of each name above
+	//SplitOnRecordName when the Parser has an AncestorComplete function.
+	ancestorSummaries map[string]*AncestorSummary
+	//uncompletedAncestors holds the summaries of ancestors (open or completed)
+	//not yet passed to AncestorComplete so split records can be counted.
+	uncompletedAncestors map[*Record]*AncestorSummary
+	//sent is the number of Records returned by next.
+	sent int
+	//unmatched is the number of lines which matched no RecordDefinition.
+	unmatched int
+	//accumulators hold the running totals of ControlTotals.
+	accumulators []*accumulator
+	//occursRules holds the RecordDefinitions with MinOccurs or MaxOccurs by
+	//ParentRecordName.
+	occursRules map[string][]*RecordDefinition
+	//occurs counts the children of each parent Record by name.
+	occurs map[*Record]map[string]int
+	//sequence checks the Records follow the RecordSequence (nil if not set).
+	sequence *sequenceChecker
+	//ancestors holds the names of the ancestors of each RecordDefinition.
+	ancestors map[string][]string
 	//For each record definition name, we remember the last record we created of that
 	//name and store it here so we can attach child records which join.
-	lastRecords := make(map[string]*Record)
-	lineNum := 0
-	for scanner.Scan() {
-		lineNum++
-		for _, recDef := range p.RecordDefinitions {
-			match, err := recDef.Match(scanner.Bytes())
-			if err = p.ErrorHandler(ConfigurationError(err)); err != nil {
+	lastRecords map[string]*Record
+	//joinIndexes holds the parents of Records with JoinOnFieldNames by key.
+	joinIndexes map[string][]*joinIndex
+	lineNum     int
+	//lineOffset is the offset of the start of the current line.
+	lineOffset int64
+	//streamDecoded is set if the source was decoded by a StreamEncoding.
+	streamDecoded bool
+	//encodings caches Encodings by name.
+	encodings map[string]Encoding
+	//done is set once the final split record has been returned by next.
+	done bool
+	//buffer holds the Records of a Buffered join (nil when streaming).
+	buffer *joinBuffer
+	//eof is set once the whole source has been read.
+	eof bool
+	//matchers holds the compiled MatchExpression of each RecordDefinition (nil
+	//if it matches every line).
+	matchers []*regexp.Regexp
+	//patterns holds the compiled Pattern of each FieldDefinition by
+	//RecordDefinition (for RecordDefinitions with any Patterns).
+	patterns map[*RecordDefinition][]*regexp.Regexp
+	//drain, if set, waits for the split records already returned by next to be
+	//processed by the Parser's Workers.
+	drain func() error
+	//current is the line read by scanLine.
+	current scannedLine
+	//replay holds lines to read again because the multi-line record they
+	//were added to was incomplete.
+	replay []scannedLine
+}
+
+//newParseState returns the state for parsing source, checking the settings
+//which depend on callbacks & compiling each MatchExpression (& the
+//RecordSequence, unless Validate has) first.
+func (p *Parser) newParseState(ctx context.Context, source io.Reader) (*parseState, error) {
+	if err := p.checkCallbacks(); err != nil {
+		return nil, err
+	}
+	patterns, err := compilePatterns(p.RecordDefinitions)
+	if err != nil {
+		return nil, err
+	}
+	matchers := make([]*regexp.Regexp, len(p.RecordDefinitions))
+	for i, recDef := range p.RecordDefinitions {
+		re, err := recDef.compiled()
+		if err != nil {
+			return nil, err
+		}
+		matchers[i] = re
+	}
+	seq, err := p.compiledSequence()
+	if err != nil {
+		return nil, err
+	}
+	ps := &parseState{
+		matchers:             matchers,
+		patterns:             patterns,
+		p:                    p,
+		ctx:                  ctx,
+		lastRecords:          make(map[string]*Record),
+		splitRecs:            make(map[string]*Record),
+		ancestors:            recordAncestors(p.RecordDefinitions),
+		ancestorSummaries:    make(map[string]*AncestorSummary),
+		uncompletedAncestors: make(map[*Record]*AncestorSummary),
+		joinIndexes:          newJoinIndexes(p.RecordDefinitions),
+		accumulators:         newAccumulators(p.RecordDefinitions),
+		occursRules:          newOccursRules(p.RecordDefinitions),
+		occurs:               make(map[*Record]map[string]int),
+		sequence:             newSequenceChecker(seq),
+		encodings:            make(map[string]Encoding),
+	}
+	if enc, ok := EncodingRegistry[p.Encoding].(StreamEncoding); ok {
+		source = enc.NewReader(source)
+		ps.streamDecoded = true
+	}
+	ps.scanner = p.newRecordScanner(source)
+	if p.JoinMode == BufferedJoinMode {
+		ps.buffer = newJoinBuffer(ps)
+	}
+	return ps, nil
+}
+
+//close releases any files used to buffer Records.
+func (ps *parseState) close() {
+	if ps.buffer != nil {
+		ps.buffer.close()
+	}
+}
+
+//encoding returns the Encoding used by recDef, or nil if the source is read
+//as it is.
+func (ps *parseState) encoding(recDef *RecordDefinition) (Encoding, error) {
+	name := recDef.Encoding
+	if name == "" {
+		if ps.streamDecoded {
+			return nil, nil
+		}
+		name = ps.p.Encoding
+	}
+	if name == "" {
+		return nil, nil
+	}
+	if enc, ok := ps.encodings[name]; ok {
+		return enc, nil
+	}
+	enc, err := GetEncoding(name)
+	if err != nil {
+		return nil, err
+	}
+	ps.encodings[name] = enc
+	return enc, nil
+}
+
+//next reads lines until a split record is complete & returns it.
+//At EOF the last split record (which may be nil) is returned with done set.
+//Subsequent calls return io.EOF.
+func (ps *parseState) next() (*Record, error) {
+	if ps.done {
+		return nil, io.EOF
+	}
+	if ps.buffer != nil {
+		return ps.nextBuffered()
+	}
+	for {
+		//Pass completed ancestors to AncestorComplete.
+		for len(ps.pending) > 0 && ps.pending[0].ancestor != nil {
+			if ps.drain != nil {
+				//Wait for the split records beneath the ancestor to be processed.
+				if err := ps.drain(); err != nil {
+					return nil, err
+				}
+			}
+			if err := ps.completeAncestor(ps.pending[0].ancestor); err != nil {
+				return nil, err
+			}
+			ps.pending = ps.pending[1:]
+		}
+		if len(ps.pending) > 0 || ps.eof {
+			break
+		}
+		if err := ps.checkContext(); err != nil {
+			return nil, err
+		}
+		if !ps.scanLine() {
+			if err := ps.scanner.Err(); err != nil {
+				return nil, SourceError{LineNum: ps.lineNum + 1, Err: err}
+			}
+			ps.eof = true
+			if err := ps.endSequence(); err != nil {
+				return nil, err
+			}
+			if err := ps.checkLastOccurs(); err != nil {
+				return nil, err
+			}
+			ps.closeSplits("")
+			if ps.p.AncestorComplete != nil {
+				ps.closeAncestors("")
+			}
+			continue
+		}
+		if err := ps.processLine(ps.current.data); err != nil {
+			return nil, err
+		}
+	}
+	//If ancestors were completed after the last split record, they are passed
+	//to AncestorComplete by the next call, which returns a nil Record.
+	var rec *Record
+	if len(ps.pending) > 0 {
+		rec = ps.pending[0].record
+		ps.pending = ps.pending[1:]
+		ps.sent++
+		if err := ps.checkTreeOccurs(rec); err != nil {
+			return nil, err
+		}
+		if ps.p.AncestorComplete != nil && rec.Name == ps.p.SplitOnRecordName {
+			ps.countSplitRecord(rec)
+		}
+	}
+	ps.done = ps.eof && len(ps.pending) == 0
+	return rec, nil
+}
+
+//checkContext returns a ContextError if the context is done.
+func (ps *parseState) checkContext() error {
+	if err := ps.ctx.Err(); err != nil {
+		return ContextError{LineNum: ps.lineNum, Err: err}
+	}
+	return nil
+}
+
+//processLine builds a Record from a single line & attaches it to the hierarchy.
+//If the line starts a new split record, the previous split record is added to
+//pending.
+func (ps *parseState) processLine(line []byte) error {
+	p := ps.p
+	//lineNum & offset are where the record starts (it may continue onto
+	//further lines).
+	lineNum := ps.lineNum
+	offset := ps.lineOffset
+	lastRecords := ps.lastRecords
+	matched := false
+	for i, recDef := range p.RecordDefinitions {
+		enc, err := ps.encoding(recDef)
+		if err != nil {
+			if err = p.ErrorHandler(err); err != nil {
 				return err
 			}
-			if !match {
-				//skip this iteration & try the next RecordDefinition
-				continue
+		}
+		//text is the line decoded to UTF-8.
+		text, err := decodeRecord(enc, line)
+		if err != nil {
+			err = ps.recordError(recDef.Name, lineNum, line, fmt.Sprintf("Error decoding record: %s", err))
+			if err = p.ErrorHandler(err); err != nil {
+				return err
 			}
-			recVals, err := recDef.RecordReader.Read(scanner.Bytes())
-			if err != nil {
-				err = RecordParseError{Text: fmt.Sprintf("Error reading from RecordReader: %s", err), RecordName: recDef.Name}
-				if err = p.ErrorHandler(err); err != nil {
+			continue
+		}
+		if re := ps.matchers[i]; re != nil && !re.Match(text) {
+			//skip this iteration & try the next RecordDefinition
+			continue
+		}
+		matched = true
+		if ml, ok := recDef.RecordReader.(MultiLineRecordReader); ok && p.RecordLength == 0 {
+			var msg string
+			if line, text, msg = ps.readContinuation(ml, enc, line, text); msg != "" {
+				//Skip the line, the following lines are read again as new records.
+				if err = p.ErrorHandler(ps.recordError(recDef.Name, lineNum, line, msg)); err != nil {
 					return err
 				}
+				break
 			}
-			rec := Record{
-				Name:     recDef.Name,
-				Fields:   make([]Field, 0),
-				Children: make([]*Record, 0),
-			}
-			for i, fldDef := range recDef.FieldDefinitions {
-				if i > len(recVals)-1 {
-					err = RecordParseError{Text: fmt.Sprintf("Past the end of available data on line %d", lineNum), RecordName: recDef.Name}
-					if err = p.ErrorHandler(err); err != nil {
-						return err
-					}
-				}
-				fldVal, valerr := fldDef.FieldType.GetValue(recVals[i])
-				if valerr != nil {
-					err = FieldParseError{
-						Text:       fmt.Sprintf("Error on line %d getting field value: %s", lineNum, valerr),
-						RecordName: recDef.Name,
-						FieldName:  fldDef.Name,
-					}
-					if err = p.ErrorHandler(err); err != nil {
-						return err
-					}
-				}
-				fld := Field{
-					Name:     fldDef.Name,
-					TypeName: fldDef.TypeName,
-					Value:    fldVal,
-				}
-				rec.Fields = append(rec.Fields, fld)
+		}
+		rec, err := ps.newRecord(recDef, enc, line, text, lineNum, offset, p.ErrorHandler)
+		if err != nil {
+			return err
+		}
+		if err = ps.checkSequence(rec); err != nil {
+			return err
+		}
+		if err = ps.accumulate(rec); err != nil {
+			return err
+		}
+		if ps.buffer != nil {
+			//Buffered joins attach the Record once its section is complete.
+			return ps.buffer.add(recDef, rec, text)
+		}
+		if last, ok := lastRecords[rec.Name]; ok && !last.isWithinSplit {
+			//Records within a split record are checked when it is returned.
+			if err = ps.checkOccurs(last); err != nil {
+				return err
 			}
-			lastRecords[rec.Name] = &rec
+		}
+		lastRecords[rec.Name] = rec
+		if len(ps.joinIndexes) > 0 {
+			ps.closeJoinIndexes(rec.Name)
+		}
 
+		if p.AncestorComplete != nil {
+			if ps.isAncestorLevel(recDef.Name) {
+				ps.openAncestor(rec)
+			}
+			ps.countDescendant(rec)
+		}
+		isSplit := p.isSplitLevel(recDef.Name)
+		if isSplit {
+			//This is a record we want to split on, if there is already a split
+			//record of this name set, we need to return it (& the split records
+			//beneath it) to clear the way for the new Record.
+			ps.closeSplits(recDef.Name)
+			ps.splitRecs[recDef.Name] = rec
 			if recDef.Name == p.SplitOnRecordName {
-				//This is a record we want to split on, if there is already a SplitRec
-				//set, we need to call the callback to clear the way for the new Record.
-				if splitRec != nil {
-					if err = p.RecordProcessor(splitRec); err != nil {
-						return err
-					}
-				}
-				splitRec = &rec
-				//Mark this record as within the split so that it will recieve children.
-				rec.isWithinSplit = true
+				ps.resetSplitIndexes()
 			}
-			//This record needs to be attached to a parent
-			if parent, ok := lastRecords[recDef.ParentRecordName]; ok {
-				if parent.isWithinSplit {
-					//If the parent is above the split in the hierarchy, we don't want to
-					//record its children as this will mean building the entire record hierarchy
-					//in referencable memory so the garbage collector won't be able to recover
-					//previously sent child records.
-					rec.isWithinSplit = true
-					parent.Children = append(parent.Children, &rec)
-				} else {
-					//If the parent is within the split, we add this record to the Children
-					//of the identified parent but we don't add the parent to the current
-					//record as this creates a circular reference. Basically, the parent /
-					//child relationships always fan out from the SplitOnRecordName.
-					rec.Parent = lastRecords[recDef.ParentRecordName]
-				}
+			//Mark this record as within the split so that it will recieve children.
+			rec.isWithinSplit = true
+		}
+		//This record needs to be attached to a parent
+		if parent, msg := ps.parent(recDef, rec); parent != nil {
+			ps.countOccurs(parent, rec.Name)
+			if parent.isWithinSplit && !isSplit {
+				//If the parent is above the split in the hierarchy, we don't want to
+				//record its children as this will mean building the entire record hierarchy
+				//in referencable memory so the garbage collector won't be able to recover
+				//previously sent child records.
+				rec.isWithinSplit = true
+				parent.Children = append(parent.Children, rec)
 			} else {
-				if recDef.ParentRecordName != "" {
-					err = RecordParseError{Text: fmt.Sprintf("No available parent record \"%s\" on line %d", recDef.ParentRecordName, lineNum), RecordName: recDef.Name}
-					if err = p.ErrorHandler(err); err != nil {
-						return err
-					}
+				//If the parent is within the split, we add this record to the Children
+				//of the identified parent but we don't add the parent to the current
+				//record as this creates a circular reference. Basically, the parent /
+				//child relationships always fan out from the SplitOnRecordName.
+				rec.Parent = parent
+			}
+		} else {
+			if recDef.ParentRecordName != "" {
+				err = ps.recordError(recDef.Name, lineNum, text, msg)
+				if err = p.ErrorHandler(err); err != nil {
+					return err
 				}
 			}
-			//break out to scan next line (don't loop over further RecordDefinitions)
+		}
+		ps.indexRecord(rec)
+		//break out to scan next line (don't loop over further RecordDefinitions)
+		break
+	}
+	if !matched {
+		return ps.unmatchedLine(line, lineNum, offset)
+	}
+	return nil
+}
+
+//newRecord reads the Fields of a Record matching recDef from line (text is line
+//decoded to UTF-8). Errors are passed to handler.
+func (ps *parseState) newRecord(recDef *RecordDefinition, enc Encoding, line []byte, text []byte, lineNum int, offset int64, handler ErrorHandler) (*Record, error) {
+	recVals, spans, err := readEncoded(recDef.RecordReader, enc, line, text)
+	if err != nil {
+		err = ps.recordError(recDef.Name, lineNum, text, fmt.Sprintf("Error reading from RecordReader: %s", err))
+		if err = handler(err); err != nil {
+			return nil, err
+		}
+	}
+	rec := &Record{
+		Name:     recDef.Name,
+		Fields:   make([]Field, 0),
+		Children: make([]*Record, 0),
+		LineNum:  lineNum,
+		Offset:   offset,
+		Raw:      append([]byte(nil), line...),
+	}
+	for i, fldDef := range recDef.FieldDefinitions {
+		if i > len(recVals)-1 {
+			err = ps.recordError(recDef.Name, lineNum, text, "Past the end of available data")
+			if err = handler(err); err != nil {
+				return nil, err
+			}
+			//There is no data for the remaining Fields.
 			break
 		}
+		fld := Field{
+			Name:     fldDef.Name,
+			TypeName: fldDef.TypeName,
+		}
+		if spans != nil {
+			fld.Start, fld.End = spans[i].Start, spans[i].End
+		}
+		var fldVal interface{}
+		var valerr error
+		snippet := recVals[i]
+		if rft, ok := fldDef.FieldType.(RawFieldType); ok {
+			raw := rawFieldData(recDef.RecordReader, enc, line, spans, i, recVals[i])
+			fldVal, valerr = rft.GetRawValue(raw)
+			snippet = fmt.Sprintf("% X", raw)
+		} else {
+			fldVal, valerr = fldDef.FieldType.GetValue(recVals[i])
+		}
+		if valerr != nil {
+			err = FieldParseError{
+				Text:       fmt.Sprintf("Error getting field value: %s", valerr),
+				RecordName: recDef.Name,
+				FieldName:  fldDef.Name,
+				LineNum:    lineNum,
+				Column:     fld.Start,
+				Snippet:    snippet,
+			}
+			if err = handler(err); err != nil {
+				return nil, err
+			}
+		} else {
+			var pattern *regexp.Regexp
+			if patterns := ps.patterns[recDef]; patterns != nil {
+				pattern = patterns[i]
+			}
+			for _, failure := range fldDef.checkRules(recVals[i], fldVal, pattern) {
+				err = handler(FieldParseError{
+					Text:       failure.text,
+					RecordName: recDef.Name,
+					FieldName:  fldDef.Name,
+					LineNum:    lineNum,
+					Column:     fld.Start,
+					Snippet:    snippet,
+					Rule:       failure.rule,
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		fld.Value = fldVal
+		rec.Fields = append(rec.Fields, fld)
+	}
+	return rec, nil
+}
+
+//readContinuation appends further lines to line (separated by the terminator
+//which ended each line) until the MultiLineRecordReader reports the record is
+//complete. It returns the joined line & the joined line decoded using enc
+//(text is line decoded). If the record is still incomplete after
+//MaxRecordLines lines, MaxRecordSize bytes or at the end of the source, msg
+//describes the problem & the lines after the first are replayed by scanLine.
+func (ps *parseState) readContinuation(ml MultiLineRecordReader, enc Encoding, line []byte, text []byte) ([]byte, []byte, string) {
+	cont := newContinuation(ml)
+	if !cont.Add(text) {
+		return line, text, ""
+	}
+	//line belongs to the scanner so copy it before appending.
+	firstLen, firstTextLen := len(line), len(text)
+	line = append([]byte(nil), line...)
+	text = append([]byte(nil), text...)
+	startLineNum := ps.lineNum
+	terminator := append([]byte(nil), ps.current.terminator...)
+	read := make([]scannedLine, 0)
+	maxLines, maxSize := ps.p.maxRecordLines(), ps.p.maxRecordSize()
+	for {
+		var msg string
+		switch {
+		case maxLines > 0 && len(read)+1 >= maxLines:
+			msg = fmt.Sprintf("Record is incomplete after %d lines (see MaxRecordLines)", maxLines)
+		case maxSize > 0 && len(line) >= maxSize:
+			msg = fmt.Sprintf("Record is incomplete after %d bytes (see MaxRecordSize)", len(line))
+		case !ps.scanLine():
+			msg = "Record is incomplete at the end of the source"
+		}
+		if msg != "" {
+			ps.replay = append(read, ps.replay...)
+			ps.lineNum = startLineNum
+			return line[:firstLen], text[:firstTextLen], msg
+		}
+		next := scannedLine{
+			data:       append([]byte(nil), ps.current.data...),
+			offset:     ps.current.offset,
+			terminator: append([]byte(nil), ps.current.terminator...),
+		}
+		read = append(read, next)
+		part := append(terminator, next.data...)
+		line = append(line, part...)
+		terminator = next.terminator
+		decoded, err := decodeRecord(enc, part)
+		if err != nil {
+			//Leave the error to be reported when the record is read.
+			return line, line, ""
+		}
+		text = append(text, decoded...)
+		if !cont.Add(decoded) {
+			return line, text, ""
+		}
+	}
+}
+
+//scannedLine is a line read from the source.
+type scannedLine struct {
+	data       []byte
+	offset     int64
+	terminator []byte
+}
+
+//scanLine reads the next line into current, replaying lines read by an
+//incomplete multi-line record first. It returns false at the end of the
+//source or on error.
+func (ps *parseState) scanLine() bool {
+	if len(ps.replay) > 0 {
+		ps.current = ps.replay[0]
+		ps.replay = ps.replay[1:]
+	} else if ps.scanner.Scan() {
+		ps.current = scannedLine{
+			data:       ps.scanner.Bytes(),
+			offset:     ps.scanner.Offset(),
+			terminator: ps.scanner.Terminator(),
+		}
+	} else {
+		return false
+	}
+	ps.lineNum++
+	ps.lineOffset = ps.current.offset
+	return true
+}
+
+//maxRecordLines returns the limit on the lines of a multi-line record (0 if
+//there is none).
+func (p *Parser) maxRecordLines() int {
+	switch {
+	case p.MaxRecordLines < 0:
+		return 0
+	case p.MaxRecordLines == 0:
+		return defaultMaxRecordLines
+	}
+	return p.MaxRecordLines
+}
+
+//maxRecordSize returns the limit on the size of a record (0 if there is none).
+func (p *Parser) maxRecordSize() int {
+	switch {
+	case p.MaxRecordSize < 0:
+		return 0
+	case p.MaxRecordSize == 0:
+		return bufio.MaxScanTokenSize
+	}
+	return p.MaxRecordSize
+}
+
+//defaultMaxRecordLines is the default MaxRecordLines.
+const defaultMaxRecordLines = 100
+
+//recordError returns a RecordParseError for the record starting on lineNum.
+func (ps *parseState) recordError(recordName string, lineNum int, line []byte, text string) RecordParseError {
+	return RecordParseError{
+		RecordName: recordName,
+		Text:       text,
+		LineNum:    lineNum,
+		Snippet:    snippet(line),
 	}
-	//Finally, send the last split record we have.
-	return p.ErrorHandler(p.RecordProcessor(splitRec))
 }
 
 //RecordProcessor defines a callback configured in the Parser.
@@ -198,6 +795,10 @@ func (p *Parser) Parse(source io.ReadCloser) error {
 //containing the current Record. Returning an error aborts processing.
 type RecordProcessor func(record *Record) error
 
+//ContextRecordProcessor is a RecordProcessor which also receives the context
+//passed to ParseContext so that it can honour cancellation & deadlines.
+type ContextRecordProcessor func(ctx context.Context, record *Record) error
+
 //ErrorHandler defines a callback configured in the parser.
 //This callback will be called whenever an error occurs & allows custom error handling.
 //If this function returns an error, the error will not be handled & processing will be aborted.
@@ -213,12 +814,80 @@ type RecordReader interface {
 	Read(data []byte) (values []string, err error)
 }
 
+//MultiLineRecordReader is implemented by RecordReaders whose records may
+//span more than one line. Incomplete returns true if data ends part way through
+//a record, in which case the next line is appended (after the line terminator)
+//& it is called again. It is not used with fixed length records.
+type MultiLineRecordReader interface {
+	Incomplete(data []byte) bool
+}
+
+//ContinuationRecordReader is implemented by MultiLineRecordReaders which can
+//check each line as it is added to a record rather than rescanning the whole
+//record.
+type ContinuationRecordReader interface {
+	NewContinuation() Continuation
+}
+
+//Continuation tracks whether a multi-line record is complete as it is read.
+//Add is called with the first line of the record, then with each line
+//terminator & following line, & returns true while the record is incomplete.
+type Continuation interface {
+	Add(data []byte) bool
+}
+
+//rescanContinuation is a Continuation for MultiLineRecordReaders which only
+//implement Incomplete.
+type rescanContinuation struct {
+	ml   MultiLineRecordReader
+	data []byte
+}
+
+func (rc *rescanContinuation) Add(data []byte) bool {
+	rc.data = append(rc.data, data...)
+	return rc.ml.Incomplete(rc.data)
+}
+
+//newContinuation returns a Continuation for ml.
+func newContinuation(ml MultiLineRecordReader) Continuation {
+	if cr, ok := ml.(ContinuationRecordReader); ok {
+		return cr.NewContinuation()
+	}
+	return &rescanContinuation{ml: ml}
+}
+
+//FieldSpan holds the start & end byte offsets of a value within a record.
+type FieldSpan struct {
+	Start int
+	End   int
+}
+
+//SpanRecordReader is implemented by RecordReaders which can report where
+//in the record each value was read from.
+type SpanRecordReader interface {
+	ReadSpans(data []byte) (values []string, spans []FieldSpan, err error)
+}
+
+//readSpans reads data using rr, including spans if rr is a SpanRecordReader.
+func readSpans(rr RecordReader, data []byte) (values []string, spans []FieldSpan, err error) {
+	if sr, ok := rr.(SpanRecordReader); ok {
+		return sr.ReadSpans(data)
+	}
+	values, err = rr.Read(data)
+	return values, nil, err
+}
+
 //Record objects contain all of the Fields which form a record & any child Records.
 type Record struct {
 	Name     string
 	Fields   []Field
 	Parent   *Record
 	Children []*Record
+	//LineNum, Offset & Raw record where the Record was read from: the physical
+	//line number, the byte offset of the start of the line & the line itself.
+	LineNum int
+	Offset  int64
+	Raw     []byte
 	//isWithinSplit is set if this record has name SplitOnRecordName
 	//or if it is a child of such a record.
 	isWithinSplit bool
@@ -237,7 +906,7 @@ func (rec *Record) FindRecord(recordName string, matches []Field) *Record {
 			if err != nil {
 				return nil
 			}
-			if fld != match {
+			if fld.Name != match.Name || fld.TypeName != match.TypeName || fld.Value != match.Value {
 				matchedKeys = false
 				break
 			}
@@ -273,4 +942,11 @@ type Field struct {
 	Name     string
 	TypeName string
 	Value    interface{}
+	//Start & End are the 0-based byte offsets of the value within the Record's
+	//Raw line (both are 0 if the RecordReader does not implement
+	//SpanRecordReader). If the Record has an Encoding & its RecordReader is not
+	//an EncodedRecordReader (eg. Delimited), they are offsets into the line
+	//decoded to UTF-8 instead.
+	Start int
+	End   int
 }
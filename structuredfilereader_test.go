@@ -2,6 +2,7 @@ package sfr
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
 	"reflect"
@@ -322,3 +323,276 @@ func TestMissingFile(t *testing.T) {
 	}
 	p.ParseFile("some", "junk", "file.dat")
 }
+
+func TestInvalidMatchExpression(t *testing.T) {
+	configStr := `
+	{
+		"SplitOnRecordName": "Bad",
+		"RecordDefinitions": [
+			{
+				"Name": "Bad",
+				"MatchExpression": "^(010",
+				"ReaderName": "Delimited",
+				"RecordReader": {
+					"Delimiter": "~"
+				}
+			}
+		]
+	}
+	`
+	_, err := NewParser(ioutil.NopCloser(strings.NewReader(configStr)), nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "\"Bad\"") {
+		t.Errorf("Expected an error naming RecordDefinition \"Bad\", got %v", err)
+	}
+}
+
+func TestMatchExpressionInCode(t *testing.T) {
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(JoinCfg)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.RecordDefinitions[0].MatchExpression = "^(010"
+	if err = p.Validate(); err == nil || !strings.Contains(err.Error(), "InvoiceHeader") {
+		t.Errorf("Expected Validate to report the MatchExpression, got %v", err)
+	}
+	//Parse reports it before reading any records.
+	err = p.Parse(ioutil.NopCloser(strings.NewReader(HierarchyData)))
+	if _, ok := err.(ConfigurationError); !ok || !strings.Contains(err.Error(), "InvoiceHeader") {
+		t.Errorf("Expected a ConfigurationError, got %v", err)
+	}
+	p.RecordDefinitions[0].MatchExpression = "^01"
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(HierarchyData))); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseChecksCallbacks(t *testing.T) {
+	cfg, err := NewParser(ioutil.NopCloser(strings.NewReader(JoinCfg)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Callbacks set in code are checked before any line is read.
+	p := Parser{
+		RecordDefinitions: cfg.RecordDefinitions,
+		SplitOnRecordName: cfg.SplitOnRecordName,
+		RecordProcessors:  map[string]RecordProcessor{"Missing": func(record *Record) error { return nil }, "InvoiceHeader": nil},
+	}
+	err = p.Parse(ioutil.NopCloser(strings.NewReader(HierarchyData)))
+	var ces ConfigurationErrors
+	if !errors.As(err, &ces) || len(ces) != 2 {
+		t.Fatalf("Expected 2 ConfigurationErrors for the processors, got %v", err)
+	}
+	p.RecordProcessor = func(record *Record) error { return nil }
+	delete(p.RecordProcessors, "InvoiceHeader")
+	err = p.Parse(ioutil.NopCloser(strings.NewReader(HierarchyData)))
+	if !errors.As(err, &ces) || len(ces) != 2 || !strings.Contains(ces[0].Error(), "Missing") || !strings.Contains(ces[1].Error(), "ErrorHandler") {
+		t.Errorf("Expected ConfigurationErrors for RecordProcessors & ErrorHandler, got %v", err)
+	}
+	p.RecordProcessors = nil
+	p.ErrorHandler = DefaultErrorHandler
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(HierarchyData))); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	configStr := `
+	{
+		"SplitOnRecordName": "Missing",
+		"RecordDefinitions": [
+			{
+				"Name": "A",
+				"ParentRecordName": "B",
+				"ReaderName": "Delimited",
+				"RecordReader": {
+					"Delimiter": ""
+				}
+			},
+			{
+				"Name": "B",
+				"ParentRecordName": "A",
+				"ReaderName": "FixedWidth",
+				"RecordReader": {
+					"Coordinates": [
+						{
+							"Start": 0,
+							"End": 5
+						},
+						{
+							"Start": 4,
+							"End": 4
+						}
+					]
+				},
+				"FieldDefinitions": [
+					{
+						"Name": "X",
+						"TypeName": "String"
+					}
+				]
+			},
+			{
+				"Name": "C",
+				"ParentRecordName": "D",
+				"ReaderName": "Delimited",
+				"RecordReader": {
+					"Delimiter": ","
+				}
+			}
+		]
+	}
+	`
+	_, err := NewParser(ioutil.NopCloser(strings.NewReader(configStr)), nil, nil)
+	errs, ok := err.(ConfigurationErrors)
+	if !ok {
+		t.Fatalf("Expected ConfigurationErrors, got %v", err)
+	}
+	expected := []string{
+		"SplitOnRecordName \"Missing\"",
+		"\"A\": ParentRecordName \"B\" creates a cycle",
+		"\"A\": Delimiter must not be empty",
+		"\"B\": ParentRecordName \"A\" creates a cycle",
+		"\"B\": 2 Coordinates defined for 1 FieldDefinitions",
+		"\"B\": Coordinate 4-4 must have",
+		"\"B\": Coordinate 4-4 overlaps 0-5",
+		"\"C\": ParentRecordName \"D\" does not match",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+	for i, exp := range expected {
+		if !strings.Contains(errs[i].Error(), exp) {
+			t.Errorf("Expected error %d to contain %s, got %s", i, exp, errs[i])
+		}
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	var lineDist *Record
+	var fieldErr FieldParseError
+	p, err := NewParser(
+		ioutil.NopCloser(strings.NewReader(JoinCfg)),
+		func(record *Record) error {
+			if lineDist == nil {
+				lineDist = record.Children[1].Children[0]
+			}
+			return nil
+		},
+		func(err error) error {
+			if fe, ok := err.(FieldParseError); ok {
+				fieldErr = fe
+				return nil
+			}
+			return err
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Replace(HierarchyData, "010~INV22222222~12345~", "010~INV22222222~12X45~", 1)
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if lineDist.LineNum != 6 || lineDist.Offset != 108 || string(lineDist.Raw) != "033ACCTNUM002" {
+		t.Errorf("Unexpected provenance: line %d, offset %d, raw %s", lineDist.LineNum, lineDist.Offset, lineDist.Raw)
+	}
+	account, _ := lineDist.GetField("Account")
+	if account.Start != 3 || account.End != 13 {
+		t.Errorf("Expected Account to span 3-13, got %d-%d", account.Start, account.End)
+	}
+	if fieldErr.LineNum != 7 || fieldErr.Column != 16 || fieldErr.Snippet != "12X45" || fieldErr.FieldName != "InvoiceAmount" {
+		t.Errorf("Unexpected FieldParseError %+v", fieldErr)
+	}
+}
+
+func TestMultiLineDelimited(t *testing.T) {
+	invoices := make([]*Record, 0)
+	var recErr RecordParseError
+	p, err := NewParser(
+		ioutil.NopCloser(strings.NewReader(JoinCfg)),
+		func(record *Record) error {
+			invoices = append(invoices, record)
+			return nil
+		},
+		func(err error) error {
+			if re, ok := err.(RecordParseError); ok {
+				recErr = re
+				return nil
+			}
+			return err
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := "010~INV1~12345~17-JUL-2019\n" +
+		"030~0001~\"Vendor notes:\n" +
+		"deliver to \"\"Dock 2\"\"\n" +
+		"before noon\"\n" +
+		"033ACCTNUM001\n" +
+		"030~0002~\"Unterminated\n"
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	line := invoices[0].Children[0]
+	desc, _ := line.GetField("Description")
+	if desc.Value != "Vendor notes:\ndeliver to \"Dock 2\"\nbefore noon" {
+		t.Errorf("Unexpected Description %q", desc.Value)
+	}
+	if line.LineNum != 2 || len(line.Children) != 1 || line.Children[0].LineNum != 5 {
+		t.Errorf("Unexpected line numbers %d & %d", line.LineNum, line.Children[0].LineNum)
+	}
+	if desc.Start != 9 || desc.End != len(line.Raw) {
+		t.Errorf("Expected Description to span 9-%d, got %d-%d", len(line.Raw), desc.Start, desc.End)
+	}
+	if recErr.LineNum != 6 || recErr.RecordName != "InvoiceLine" {
+		t.Errorf("Expected a RecordParseError for InvoiceLine on line 6, got %v", recErr)
+	}
+}
+
+func TestMultiLineUnterminated(t *testing.T) {
+	var invoices []*Record
+	var recErrs []RecordParseError
+	p, err := NewParser(
+		ioutil.NopCloser(strings.NewReader(JoinCfg)),
+		func(record *Record) error {
+			invoices = append(invoices, record)
+			return nil
+		},
+		func(err error) error {
+			if re, ok := err.(RecordParseError); ok {
+				recErrs = append(recErrs, re)
+				return nil
+			}
+			return err
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Lines after an unterminated quote are read again as records.
+	data := "010~INV1~12345~17-JUL-2019\r\n" +
+		"030~0001~\"oops\r\n" +
+		"030~0002~Line Two\r\n" +
+		"033ACCTNUM002\r\n" +
+		"030~0003~\"Two\r\nlines\"\r\n" +
+		"010~INV2~12345~17-JUL-2019\r\n"
+	p.MaxRecordLines = 3
+	if err = p.Parse(ioutil.NopCloser(strings.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if len(recErrs) != 1 || recErrs[0].LineNum != 2 || recErrs[0].Snippet != "030~0001~\"oops" {
+		t.Fatalf("Expected a RecordParseError for line 2, got %v", recErrs)
+	}
+	if len(invoices) != 2 || len(invoices[0].Children) != 2 {
+		t.Fatalf("Expected 2 invoices & 2 lines, got %v", invoices)
+	}
+	lines := invoices[0].Children
+	desc, _ := lines[1].GetField("Description")
+	if lines[0].LineNum != 3 || len(lines[0].Children) != 1 || lines[1].LineNum != 5 || desc.Value != "Two\r\nlines" || invoices[1].LineNum != 7 {
+		t.Errorf("Unexpected lines %v, %v (%q)", lines[0], lines[1], desc.Value)
+	}
+	if string(lines[1].Raw) != "030~0003~\"Two\r\nlines\"" {
+		t.Errorf("Expected the original line ending in Raw, got %q", lines[1].Raw)
+	}
+}
@@ -0,0 +1,40 @@
+package sfr
+
+const (
+	//SkipUnmatchedLines counts & skips lines which match no RecordDefinition.
+	SkipUnmatchedLines = "Skip"
+	//ErrorOnUnmatchedLines passes an UnmatchedLineError to the ErrorHandler for
+	//lines which match no RecordDefinition.
+	ErrorOnUnmatchedLines = "Error"
+	//HandleUnmatchedLines passes lines which match no RecordDefinition to the
+	//Parser's UnmatchedLineHandler.
+	HandleUnmatchedLines = "Handler"
+)
+
+//UnmatchedLineHandler is called with the raw bytes & line number of a line
+//which matches no RecordDefinition. The data is only valid during the call.
+//Returning an error aborts processing.
+type UnmatchedLineHandler func(data []byte, lineNum int) error
+
+//UnmatchedLineCount returns the number of lines which matched no
+//RecordDefinition in the last Parse (or ParseFile etc.) to finish. If the
+//Parser is used by several Parse calls at once, use Records instead, whose
+//UnmatchedLineCount belongs to that iteration.
+func (p *Parser) UnmatchedLineCount() int {
+	count, _ := p.unmatchedLines.Load().(int)
+	return count
+}
+
+//unmatchedLine applies the Parser's UnmatchedLinePolicy to a line which
+//matched no RecordDefinition.
+func (ps *parseState) unmatchedLine(line []byte, lineNum int, offset int64) error {
+	ps.unmatched++
+	p := ps.p
+	switch p.UnmatchedLinePolicy {
+	case ErrorOnUnmatchedLines:
+		return p.ErrorHandler(UnmatchedLineError{LineNum: lineNum, Offset: offset, Snippet: snippet(line)})
+	case HandleUnmatchedLines:
+		return p.UnmatchedLineHandler(line, lineNum)
+	}
+	return nil
+}
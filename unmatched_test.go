@@ -0,0 +1,65 @@
+package sfr
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const unmatchedData = "H,PO1\nL,PO1,1\nX,NEW RECORD TYPE\nS,PO1,1,5\nX,ANOTHER"
+
+func TestUnmatchedLinePolicy(t *testing.T) {
+	newParser := func(handler ErrorHandler) *Parser {
+		p, err := NewParser(ioutil.NopCloser(strings.NewReader(joinCfg)), nil, handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &p
+	}
+
+	//Skip (the default) counts the lines.
+	p := newParser(nil)
+	if err := p.Parse(ioutil.NopCloser(strings.NewReader(unmatchedData))); err != nil {
+		t.Fatal(err)
+	}
+	if p.UnmatchedLineCount() != 2 {
+		t.Errorf("Expected 2 unmatched lines, got %d", p.UnmatchedLineCount())
+	}
+
+	//Error passes an UnmatchedLineError to the ErrorHandler.
+	p = newParser(nil)
+	p.UnmatchedLinePolicy = ErrorOnUnmatchedLines
+	err := p.Parse(ioutil.NopCloser(strings.NewReader(unmatchedData)))
+	var ule UnmatchedLineError
+	if !errors.As(err, &ule) || ule.LineNum != 3 || ule.Offset != 14 || ule.Snippet != "X,NEW RECORD TYPE" {
+		t.Errorf("Expected an UnmatchedLineError for line 3, got %v", err)
+	}
+
+	//Handler receives the raw line.
+	p = newParser(nil)
+	p.UnmatchedLinePolicy = HandleUnmatchedLines
+	lines := make([]string, 0)
+	p.UnmatchedLineHandler = func(data []byte, lineNum int) error {
+		lines = append(lines, string(data))
+		return nil
+	}
+	it := p.Records(ioutil.NopCloser(strings.NewReader(unmatchedData)))
+	for it.Next() {
+	}
+	if it.Err() != nil || len(lines) != 2 || lines[1] != "X,ANOTHER" || it.UnmatchedLineCount() != 2 {
+		t.Errorf("Unexpected unmatched lines %v (%v)", lines, it.Err())
+	}
+
+	//The handler is checked before any line is read.
+	p.UnmatchedLineHandler = nil
+	err = p.Parse(ioutil.NopCloser(strings.NewReader("H,PO1")))
+	var ces ConfigurationErrors
+	if !errors.As(err, &ces) || len(ces) != 1 {
+		t.Errorf("Expected a ConfigurationError without an UnmatchedLineHandler, got %v", err)
+	}
+	//NewParser accepts the policy as the handler can only be set afterwards.
+	if _, err = NewParser(ioutil.NopCloser(strings.NewReader(strings.Replace(joinCfg, `"SplitOnRecordName"`, `"UnmatchedLinePolicy": "Handler", "SplitOnRecordName"`, 1))), nil, nil); err != nil {
+		t.Errorf("Expected NewParser to accept UnmatchedLinePolicy \"Handler\", got %v", err)
+	}
+}
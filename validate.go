@@ -0,0 +1,325 @@
+package sfr
+
+import (
+	"fmt"
+	"sort"
+)
+
+//RecordReaderValidator is implemented by RecordReaders which can check their
+//own configuration against the RecordDefinition they belong to.
+type RecordReaderValidator interface {
+	Validate(recDef *RecordDefinition) []error
+}
+
+//Validate checks the Parser configuration for consistency, returning a
+//ConfigurationErrors listing every problem found (or nil). It checks that:
+//1. Record names are unique & each ParentRecordName refers to a RecordDefinition.
+//2. Parent relationships do not form a cycle.
+//3. SplitOnRecordName refers to a RecordDefinition & the framing is valid.
+//4. Each MatchExpression compiles (Validate compiles them), each
+//   RecordDefinition has a RecordReader & each FieldDefinition a FieldType
+//   & consistent validation rules.
+//5. Encodings exist & StreamEncodings (such as UTF-16) are only set on the Parser.
+//6. JoinOnFieldNames name fields of the RecordDefinition & its parent & the
+//   JoinMode settings are valid.
+//7. ControlTotals refer to defined Records & Fields, MinOccurs/MaxOccurs are
+//   valid & the RecordSequence compiles using defined record names.
+//8. RecordReaders implementing RecordReaderValidator are valid (for example,
+//   FixedWidth coordinates don't overlap & Delimited delimiters are not empty).
+//NewParser calls Validate after reading the configuration. Callbacks are
+//usually set afterwards, so Parse & Records check them again (& those which
+//must be set) before reading the source.
+func (p *Parser) Validate() error {
+	errs := make(ConfigurationErrors, 0)
+	recDefs := make(map[string]*RecordDefinition)
+	for _, recDef := range p.RecordDefinitions {
+		if _, ok := recDefs[recDef.Name]; ok {
+			errs = append(errs, fmt.Errorf("RecordDefinition \"%s\" is defined more than once", recDef.Name))
+		}
+		recDefs[recDef.Name] = recDef
+	}
+
+	if p.RecordLength < 0 {
+		errs = append(errs, fmt.Errorf("RecordLength must not be negative"))
+	}
+	if p.JoinIndexSize < -1 {
+		errs = append(errs, fmt.Errorf("JoinIndexSize must be -1 (unlimited), 0 (the default) or a positive number"))
+	}
+	if p.MaxRecordLines < -1 {
+		errs = append(errs, fmt.Errorf("MaxRecordLines must be -1 (unlimited), 0 (the default) or a positive number"))
+	}
+	if p.MaxRecordSize < -1 {
+		errs = append(errs, fmt.Errorf("MaxRecordSize must be -1 (unlimited), 0 (the default) or a positive size"))
+	}
+	if p.RecordLength > 0 && p.RecordTerminator != "" {
+		errs = append(errs, fmt.Errorf("RecordLength & RecordTerminator cannot both be set"))
+	}
+
+	switch p.JoinMode {
+	case "", StreamingJoinMode:
+		if p.JoinSectionRecordName != "" || p.JoinBufferSize != 0 || p.JoinSpillToDisk {
+			errs = append(errs, fmt.Errorf("JoinSectionRecordName, JoinBufferSize & JoinSpillToDisk require JoinMode \"%s\"", BufferedJoinMode))
+		}
+	case BufferedJoinMode:
+		if p.JoinBufferSize < 0 {
+			errs = append(errs, fmt.Errorf("JoinBufferSize must not be negative"))
+		}
+		if _, ok := recDefs[p.JoinSectionRecordName]; p.JoinSectionRecordName != "" && !ok {
+			errs = append(errs, fmt.Errorf("JoinSectionRecordName \"%s\" does not match a RecordDefinition", p.JoinSectionRecordName))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("Invalid JoinMode \"%s\"", p.JoinMode))
+	}
+
+	errs = append(errs, p.validateCallbacks()...)
+
+	switch p.UnmatchedLinePolicy {
+	case "", SkipUnmatchedLines, ErrorOnUnmatchedLines, HandleUnmatchedLines:
+	default:
+		errs = append(errs, fmt.Errorf("Invalid UnmatchedLinePolicy \"%s\"", p.UnmatchedLinePolicy))
+	}
+
+	if p.RecordSequence != "" {
+		if seq, err := compileRecordSequence(p.RecordSequence); err != nil {
+			errs = append(errs, err)
+		} else {
+			p.sequence, p.sequenceGrammar = seq, p.RecordSequence
+			names := make([]string, 0, len(seq.names))
+			for name := range seq.names {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if _, ok := recDefs[name]; !ok {
+					errs = append(errs, fmt.Errorf("RecordSequence name \"%s\" does not match a RecordDefinition", name))
+				}
+			}
+		}
+	}
+
+	_, streamDecoded := EncodingRegistry[p.Encoding].(StreamEncoding)
+	if p.Encoding != "" {
+		if _, err := GetEncoding(p.Encoding); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, ok := recDefs[p.SplitOnRecordName]; !ok {
+		errs = append(errs, fmt.Errorf("SplitOnRecordName \"%s\" does not match a RecordDefinition", p.SplitOnRecordName))
+	}
+
+	for _, recDef := range p.RecordDefinitions {
+		if recDef.ParentRecordName != "" {
+			if _, ok := recDefs[recDef.ParentRecordName]; !ok {
+				errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": ParentRecordName \"%s\" does not match a RecordDefinition", recDef.Name, recDef.ParentRecordName))
+			} else if hasParentCycle(recDef, recDefs) {
+				errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": ParentRecordName \"%s\" creates a cycle", recDef.Name, recDef.ParentRecordName))
+			}
+		}
+		if err := recDef.Compile(); err != nil {
+			errs = append(errs, err)
+		}
+		errs = append(errs, validateJoin(recDef, recDefs)...)
+		errs = append(errs, validateControlTotals(recDef, recDefs)...)
+		if recDef.MinOccurs < 0 || recDef.MaxOccurs < 0 || (recDef.MaxOccurs > 0 && recDef.MinOccurs > recDef.MaxOccurs) {
+			errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": MinOccurs %d & MaxOccurs %d must not be negative & MinOccurs must not exceed MaxOccurs", recDef.Name, recDef.MinOccurs, recDef.MaxOccurs))
+		} else if recDef.ParentRecordName == "" && (recDef.MinOccurs > 0 || recDef.MaxOccurs > 0) {
+			errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": MinOccurs & MaxOccurs require a ParentRecordName", recDef.Name))
+		}
+		if recDef.RecordReader == nil {
+			errs = append(errs, fmt.Errorf("RecordDefinition \"%s\" has no RecordReader", recDef.Name))
+		} else if validator, ok := recDef.RecordReader.(RecordReaderValidator); ok {
+			for _, err := range validator.Validate(recDef) {
+				errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": %s", recDef.Name, err))
+			}
+		}
+		if recDef.Encoding != "" {
+			if enc, err := GetEncoding(recDef.Encoding); err != nil {
+				errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": %s", recDef.Name, err))
+			} else if _, ok := enc.(StreamEncoding); ok {
+				errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": Encoding \"%s\" can only be set for the whole Parser", recDef.Name, recDef.Encoding))
+			} else if streamDecoded {
+				errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": Encoding cannot be overridden when the Parser's Encoding is \"%s\"", recDef.Name, p.Encoding))
+			}
+		}
+		for i, fldDef := range recDef.FieldDefinitions {
+			if fldDef.FieldType == nil {
+				errs = append(errs, fmt.Errorf("RecordDefinition \"%s\", FieldDefinition \"%s\" has no FieldType", recDef.Name, fldDef.Name))
+			}
+			for _, err := range validateRules(&recDef.FieldDefinitions[i]) {
+				errs = append(errs, fmt.Errorf("RecordDefinition \"%s\", FieldDefinition \"%s\": %s", recDef.Name, fldDef.Name, err))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+//validateCallbacks checks the settings which depend on the callbacks which
+//have been set. As callbacks can't be read from JSON they are usually set after
+//NewParser, so Parse & Records check them again (see checkCallbacks).
+func (p *Parser) validateCallbacks() []error {
+	errs := make([]error, 0)
+	if p.AncestorComplete != nil && p.JoinMode == BufferedJoinMode {
+		errs = append(errs, fmt.Errorf("AncestorComplete is not supported by JoinMode \"%s\"", BufferedJoinMode))
+	}
+	names := make([]string, 0, len(p.RecordProcessors))
+	for name := range p.RecordProcessors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !p.hasRecordDefinition(name) {
+			errs = append(errs, fmt.Errorf("RecordProcessors name \"%s\" does not match a RecordDefinition", name))
+		}
+	}
+	return errs
+}
+
+//checkCallbacks returns ConfigurationErrors (or nil) for the problems found by
+//validateCallbacks & for callbacks which must be set before the source is read.
+//Validate can't require these as NewParser validates before they can be set.
+func (p *Parser) checkCallbacks() error {
+	errs := p.validateCallbacks()
+	if p.ErrorHandler == nil {
+		errs = append(errs, fmt.Errorf("ErrorHandler must be set"))
+	}
+	if p.UnmatchedLinePolicy == HandleUnmatchedLines && p.UnmatchedLineHandler == nil {
+		errs = append(errs, fmt.Errorf("UnmatchedLinePolicy \"%s\" requires an UnmatchedLineHandler", HandleUnmatchedLines))
+	}
+	if len(errs) > 0 {
+		return ConfigurationErrors(errs)
+	}
+	return nil
+}
+
+//checkProcessors returns ConfigurationErrors (or nil) if Parse can't call a
+//RecordProcessor for every split record. (Records only uses the names in
+//RecordProcessors, so their RecordProcessors may be nil.)
+func (p *Parser) checkProcessors() error {
+	errs := make(ConfigurationErrors, 0)
+	if p.RecordProcessor == nil && p.ContextRecordProcessor == nil {
+		errs = append(errs, fmt.Errorf("A RecordProcessor or ContextRecordProcessor must be set"))
+	}
+	names := make([]string, 0, len(p.RecordProcessors))
+	for name, rp := range p.RecordProcessors {
+		if rp == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("RecordProcessors entry \"%s\" is nil", name))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+//hasRecordDefinition returns true if one of the Parser's RecordDefinitions is
+//named name.
+func (p *Parser) hasRecordDefinition(name string) bool {
+	for _, recDef := range p.RecordDefinitions {
+		if recDef.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//validateJoin checks the JoinOnFieldNames of recDef.
+func validateJoin(recDef *RecordDefinition, recDefs map[string]*RecordDefinition) []error {
+	errs := make([]error, 0)
+	if len(recDef.JoinOnFieldNames) == 0 {
+		if len(recDef.ParentJoinOnFieldNames) > 0 {
+			errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": ParentJoinOnFieldNames requires JoinOnFieldNames", recDef.Name))
+		}
+		return errs
+	}
+	if recDef.ParentRecordName == "" {
+		return append(errs, fmt.Errorf("RecordDefinition \"%s\": JoinOnFieldNames requires a ParentRecordName", recDef.Name))
+	}
+	if len(recDef.ParentJoinOnFieldNames) > 0 && len(recDef.ParentJoinOnFieldNames) != len(recDef.JoinOnFieldNames) {
+		errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": %d ParentJoinOnFieldNames defined for %d JoinOnFieldNames", recDef.Name, len(recDef.ParentJoinOnFieldNames), len(recDef.JoinOnFieldNames)))
+	}
+	for _, name := range recDef.JoinOnFieldNames {
+		if !recDef.hasField(name) {
+			errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": JoinOnFieldNames field \"%s\" is not defined", recDef.Name, name))
+		}
+	}
+	if parent, ok := recDefs[recDef.ParentRecordName]; ok {
+		for _, name := range recDef.parentJoinFieldNames() {
+			if !parent.hasField(name) {
+				errs = append(errs, fmt.Errorf("RecordDefinition \"%s\": parent \"%s\" has no join field \"%s\"", recDef.Name, parent.Name, name))
+			}
+		}
+	}
+	return errs
+}
+
+//hasField returns true if rd has a FieldDefinition with the given name.
+func (rd *RecordDefinition) hasField(name string) bool {
+	for _, fldDef := range rd.FieldDefinitions {
+		if fldDef.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//hasParentCycle returns true if following ParentRecordNames from recDef leads
+//back to recDef.
+func hasParentCycle(recDef *RecordDefinition, recDefs map[string]*RecordDefinition) bool {
+	visited := make(map[string]bool)
+	for parent, ok := recDefs[recDef.ParentRecordName]; ok; parent, ok = recDefs[parent.ParentRecordName] {
+		if parent.Name == recDef.Name {
+			return true
+		}
+		if visited[parent.Name] {
+			//A cycle above this record which will be reported for its members.
+			return false
+		}
+		visited[parent.Name] = true
+	}
+	return false
+}
+
+//Validate checks the Coordinates have Start < End, don't overlap & match the
+//number of FieldDefinitions & that Pad is a single byte & Justify is valid.
+func (fwr FixedWidthRecordReader) Validate(recDef *RecordDefinition) []error {
+	errs := make([]error, 0)
+	if len(fwr.Coordinates) != len(recDef.FieldDefinitions) {
+		errs = append(errs, fmt.Errorf("%d Coordinates defined for %d FieldDefinitions", len(fwr.Coordinates), len(recDef.FieldDefinitions)))
+	}
+	coords := make([]FixedWidthFieldCoordinate, len(fwr.Coordinates))
+	copy(coords, fwr.Coordinates)
+	for _, coord := range coords {
+		if coord.Start < 0 || coord.Start >= coord.End {
+			errs = append(errs, fmt.Errorf("Coordinate %d-%d must have 0 <= Start < End", coord.Start, coord.End))
+		}
+		if len(coord.Pad) > 1 {
+			errs = append(errs, fmt.Errorf("Coordinate %d-%d Pad \"%s\" must be a single byte", coord.Start, coord.End, coord.Pad))
+		}
+		if coord.Justify != "" && coord.Justify != "Left" && coord.Justify != "Right" {
+			errs = append(errs, fmt.Errorf("Coordinate %d-%d has invalid Justify \"%s\"", coord.Start, coord.End, coord.Justify))
+		}
+	}
+	sort.Slice(coords, func(i, j int) bool { return coords[i].Start < coords[j].Start })
+	for i := 1; i < len(coords); i++ {
+		if coords[i].Start < coords[i-1].End {
+			errs = append(errs, fmt.Errorf("Coordinate %d-%d overlaps %d-%d", coords[i].Start, coords[i].End, coords[i-1].Start, coords[i-1].End))
+		}
+	}
+	return errs
+}
+
+//Validate checks the Delimiter is not empty.
+func (dr DelimitedRecordReader) Validate(recDef *RecordDefinition) []error {
+	if dr.Delimiter == "" {
+		return []error{fmt.Errorf("Delimiter must not be empty")}
+	}
+	return nil
+}
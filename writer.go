@@ -0,0 +1,154 @@
+package sfr
+
+import (
+	"fmt"
+	"io"
+)
+
+//RecordWriter implementations write slices of strings into records.
+//It is the reverse of RecordReader & is implemented by the built-in
+//RecordReaders so that the same configuration can be used to read & write
+//a file.
+type RecordWriter interface {
+	Write(values []string) (data []byte, err error)
+}
+
+//FieldFormatter is implemented by FieldTypes which can convert a Field value
+//back into the text it was read from.
+type FieldFormatter interface {
+	FormatValue(value interface{}) (string, error)
+}
+
+//RawFieldFormatter is implemented by FieldTypes (such as PackedDecimal) which
+//encode values as binary data. Writers use it to fill fields of a known width
+//so that, for example, negative Binary values are sign extended.
+type RawFieldFormatter interface {
+	FormatRawValue(value interface{}, width int) ([]byte, error)
+}
+
+//FieldWidthWriter is implemented by RecordWriters which write each value into
+//a field of a fixed number of bytes.
+type FieldWidthWriter interface {
+	FieldWidths() []int
+}
+
+//Writer writes Records to an io.Writer using the RecordReaders & FieldTypes
+//configured in its RecordDefinitions. Records produced by a Parser using the
+//same RecordDefinitions will be written back in the layout they were read from.
+type Writer struct {
+	RecordDefinitions []*RecordDefinition
+	w                 io.Writer
+	recDefs           map[string]*RecordDefinition
+	//ancestors holds the last ancestor Record written at each depth above
+	//the Record passed to Write so each ancestor is only written once.
+	ancestors []*Record
+}
+
+//NewWriter returns a Writer which writes Records to w using recDefs.
+func NewWriter(w io.Writer, recDefs []*RecordDefinition) *Writer {
+	wr := &Writer{
+		RecordDefinitions: recDefs,
+		w:                 w,
+		recDefs:           make(map[string]*RecordDefinition),
+	}
+	for _, recDef := range recDefs {
+		wr.recDefs[recDef.Name] = recDef
+	}
+	return wr
+}
+
+//NewWriter returns a Writer which writes Records to w using the Parser's
+//RecordDefinitions.
+func (p *Parser) NewWriter(w io.Writer) *Writer {
+	return NewWriter(w, p.RecordDefinitions)
+}
+
+//Write writes record, followed by all of its Children. Any Parent records
+//which have not already been written (ie. records above SplitOnRecordName)
+//are written first. Records above SplitOnRecordName are only written as the
+//ancestors of a split record, so trailers & parents without any split records
+//are not written.
+func (wr *Writer) Write(record *Record) error {
+	if record == nil {
+		return nil
+	}
+	//Build the list of ancestors from the top of the hierarchy down.
+	ancestors := make([]*Record, 0)
+	for parent := record.Parent; parent != nil; parent = parent.Parent {
+		ancestors = append([]*Record{parent}, ancestors...)
+	}
+	for i, ancestor := range ancestors {
+		if i < len(wr.ancestors) && wr.ancestors[i] == ancestor {
+			continue
+		}
+		if err := wr.writeRecord(ancestor); err != nil {
+			return err
+		}
+		//Anything previously written beneath this depth belongs to an old ancestor.
+		wr.ancestors = append(wr.ancestors[:i], ancestor)
+	}
+	wr.ancestors = wr.ancestors[:len(ancestors)]
+	return wr.writeTree(record)
+}
+
+//writeTree writes record & its Children depth first.
+func (wr *Writer) writeTree(record *Record) error {
+	if err := wr.writeRecord(record); err != nil {
+		return err
+	}
+	for _, child := range record.Children {
+		if err := wr.writeTree(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//writeRecord writes a single record without its Children.
+func (wr *Writer) writeRecord(record *Record) error {
+	recDef, ok := wr.recDefs[record.Name]
+	if !ok {
+		return ConfigurationError(fmt.Errorf("No RecordDefinition named \"%s\"", record.Name))
+	}
+	recWriter, ok := recDef.RecordReader.(RecordWriter)
+	if !ok {
+		return ConfigurationError(fmt.Errorf("RecordReader for RecordDefinition \"%s\" does not implement RecordWriter", recDef.Name))
+	}
+	var widths []int
+	if fww, ok := recWriter.(FieldWidthWriter); ok {
+		widths = fww.FieldWidths()
+	}
+	values := make([]string, len(recDef.FieldDefinitions))
+	for i, fldDef := range recDef.FieldDefinitions {
+		fld, err := record.GetField(fldDef.Name)
+		if err != nil {
+			return RecordParseError{Text: err.Error(), RecordName: record.Name}
+		}
+		if rawFormatter, ok := fldDef.FieldType.(RawFieldFormatter); ok && i < len(widths) {
+			var data []byte
+			data, err = rawFormatter.FormatRawValue(fld.Value, widths[i])
+			values[i] = string(data)
+		} else {
+			formatter, ok := fldDef.FieldType.(FieldFormatter)
+			if !ok {
+				return ConfigurationError(fmt.Errorf("FieldType \"%s\" of Field \"%s\" does not implement FieldFormatter", fldDef.TypeName, fldDef.Name))
+			}
+			values[i], err = formatter.FormatValue(fld.Value)
+		}
+		if err != nil {
+			return FieldParseError{
+				Text:       fmt.Sprintf("Error formatting field value: %s", err),
+				RecordName: record.Name,
+				FieldName:  fldDef.Name,
+			}
+		}
+	}
+	data, err := recWriter.Write(values)
+	if err != nil {
+		return RecordParseError{Text: fmt.Sprintf("Error writing to RecordWriter: %s", err), RecordName: record.Name}
+	}
+	if _, err = wr.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
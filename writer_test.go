@@ -0,0 +1,268 @@
+package sfr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+//clearProvenance removes the source location from a Record hierarchy so that
+//Records read from different files can be compared.
+func clearProvenance(record *Record) {
+	record.LineNum, record.Offset, record.Raw = 0, 0, nil
+	for i := range record.Fields {
+		record.Fields[i].Start, record.Fields[i].End = 0, 0
+	}
+	for _, child := range record.Children {
+		clearProvenance(child)
+	}
+	if record.Parent != nil {
+		clearProvenance(record.Parent)
+	}
+}
+
+//parseToJSON parses data using config & returns the json encoding (without
+//provenance) of each Record sent to the RecordProcessor along with the Records
+//themselves.
+func parseToJSON(t *testing.T, config string, data []byte) ([]string, []*Record) {
+	encoded := make([]string, 0)
+	records := make([]*Record, 0)
+	p, err := NewParser(
+		ioutil.NopCloser(strings.NewReader(config)),
+		func(record *Record) error {
+			if record == nil {
+				return nil
+			}
+			clearProvenance(record)
+			b, err := json.Marshal(record)
+			encoded = append(encoded, string(b))
+			records = append(records, record)
+			return err
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Parse(ioutil.NopCloser(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	return encoded, records
+}
+
+func testRoundTrip(t *testing.T, config string, data []byte) {
+	before, records := parseToJSON(t, config, data)
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(config)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := p.NewWriter(&buf)
+	for _, record := range records {
+		if err = w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, _ := parseToJSON(t, config, buf.Bytes())
+	if len(before) != len(after) {
+		t.Fatalf("Expected %d records, got %d:\n%s", len(before), len(after), buf.String())
+	}
+	for i := range before {
+		if before[i] != after[i] {
+			t.Errorf("Record %d differs after round trip.\nExpected: %s\nGot:      %s", i, before[i], after[i])
+		}
+	}
+}
+
+func TestWriteRoundTripJoins(t *testing.T) {
+	testRoundTrip(t, JoinCfg, []byte(HierarchyData))
+}
+
+func TestWriteRoundTripDelimitedPO(t *testing.T) {
+	config, err := ioutil.ReadFile("testfiles/DelimitedPurchaseOrder/po.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile("testfiles/DelimitedPurchaseOrder/po.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRoundTrip(t, string(config), data)
+}
+
+const batchTrailerCfg = `
+{
+	"SplitOnRecordName": "Header",
+	"RecordDefinitions": [
+		{
+			"Name": "Batch",
+			"MatchExpression": "^B",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": "~"},
+			"FieldDefinitions": [
+				{"Name": "RecordID", "TypeName": "String"},
+				{"Name": "BatchNumber", "TypeName": "String"}
+			]
+		},
+		{
+			"Name": "Header",
+			"MatchExpression": "^H",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": "~"},
+			"ParentRecordName": "Batch",
+			"FieldDefinitions": [
+				{"Name": "RecordID", "TypeName": "String"},
+				{"Name": "HeaderNumber", "TypeName": "String"}
+			]
+		},
+		{
+			"Name": "Trailer",
+			"MatchExpression": "^T",
+			"ReaderName": "Delimited",
+			"RecordReader": {"Delimiter": "~"},
+			"ParentRecordName": "Batch",
+			"FieldDefinitions": [
+				{"Name": "RecordID", "TypeName": "String"},
+				{"Name": "HeaderCount", "TypeName": "String"}
+			]
+		}
+	]
+}
+`
+
+//Records above SplitOnRecordName are only written as the ancestors of a split
+//record, so trailers & batches without any split records are not reproduced.
+func TestWriteRoundTripDropsTrailers(t *testing.T) {
+	data := "B~1\nH~1\nH~2\nT~2\nB~2\nT~0\n"
+	_, records := parseToJSON(t, batchTrailerCfg, []byte(data))
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(batchTrailerCfg)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := p.NewWriter(&buf)
+	for _, record := range records {
+		if err = w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := "B~1\nH~1\nH~2\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestFixedWidthWriteJustify(t *testing.T) {
+	fwr := FixedWidthRecordReader{
+		Coordinates: []FixedWidthFieldCoordinate{
+			{Start: 0, End: 3},
+			{Start: 3, End: 9, Justify: "Right", Pad: "0"},
+			{Start: 9, End: 13},
+		},
+	}
+	data, err := fwr.Write([]string{"AB", "-42", "X"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "AB -00042X   " {
+		t.Errorf("Expected \"AB -00042X   \", got \"%s\"", data)
+	}
+	if _, err = fwr.Write([]string{"ABCD", "1", "X"}); err == nil {
+		t.Error("Expected an error writing a value wider than its field")
+	}
+}
+
+const fixedWidthNumberCfg = `
+{
+	"SplitOnRecordName": "Line",
+	"RecordDefinitions": [
+		{
+			"Name": "Line",
+			"ReaderName": "FixedWidth",
+			"RecordReader": {
+				"Coordinates": [
+					{"Start": 0, "End": 4},
+					{"Start": 4, "End": 10},
+					{"Start": 10, "End": 16, "Justify": "Right", "Pad": "0"}
+				]
+			},
+			"FieldDefinitions": [
+				{"Name": "Item", "TypeName": "String"},
+				{"Name": "Qty", "TypeName": "Number"},
+				{"Name": "Price", "TypeName": "Number", "FieldType": {"ConvertToDecimalPlaces": 2}}
+			]
+		}
+	]
+}
+`
+
+func TestWriteRoundTripFixedWidthNumber(t *testing.T) {
+	testRoundTrip(t, fixedWidthNumberCfg, []byte("A1  000042001250\nB2  -7    -00099\n"))
+}
+
+//copybookTypesCfg is a fixed width layout using the field types produced by
+//ParseCopybook.
+const copybookTypesCfg = `
+{
+	"SplitOnRecordName": "Account",
+	"RecordDefinitions": [
+		{
+			"Name": "Account",
+			"ReaderName": "FixedWidth",
+			"RecordReader": {
+				"Coordinates": [
+					{"Start": 0, "End": 4},
+					{"Start": 4, "End": 8},
+					{"Start": 8, "End": 10},
+					{"Start": 10, "End": 15}
+				]
+			},
+			"FieldDefinitions": [
+				{"Name": "ID", "TypeName": "String"},
+				{"Name": "Balance", "TypeName": "PackedDecimal", "FieldType": {"ConvertToDecimalPlaces": 2}},
+				{"Name": "Count", "TypeName": "Binary", "FieldType": {"Signed": true}},
+				{"Name": "Adjustment", "TypeName": "ZonedDecimal", "FieldType": {"ConvertToDecimalPlaces": 1}}
+			]
+		}
+	]
+}
+`
+
+func TestWriteRoundTripCopybookTypes(t *testing.T) {
+	var data []byte
+	data = append(data, "A001"...)
+	data = append(data, 0x00, 0x12, 0x34, 0x5C, 0xFF, 0xFF)
+	data = append(data, "0042R\n"...)
+	data = append(data, "A002"...)
+	data = append(data, 0x00, 0x00, 0x00, 0x1D, 0x00, 0x07)
+	data = append(data, "00120\n"...)
+	testRoundTrip(t, copybookTypesCfg, data)
+
+	_, records := parseToJSON(t, copybookTypesCfg, data)
+	p, err := NewParser(ioutil.NopCloser(strings.NewReader(copybookTypesCfg)), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := p.NewWriter(&buf)
+	for _, record := range records {
+		if err = w.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Expected\n% X\ngot\n% X", data, buf.Bytes())
+	}
+}
+
+func TestValidateFixedWidthPad(t *testing.T) {
+	cfg := strings.Replace(fixedWidthNumberCfg, `"Pad": "0"`, `"Pad": "é"`, 1)
+	_, err := NewParser(ioutil.NopCloser(strings.NewReader(cfg)), nil, nil)
+	var ces ConfigurationErrors
+	if !errors.As(err, &ces) || len(ces) != 1 {
+		t.Errorf("Expected 1 ConfigurationError, got %v", err)
+	}
+}